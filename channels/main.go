@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"sync"
 	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
 )
 
 func basicReadWriteExample() {
@@ -127,7 +129,7 @@ func producerConsumerPattern() {
 
 }
 
-func basicSelect() {
+func basicSelect(clk clock.Clock) {
 	fmt.Println("############## basicSelect ##############")
 
 	c1 := make(chan interface{})
@@ -136,7 +138,7 @@ func basicSelect() {
 	var c3 chan<- interface{}
 
 	go func() {
-		time.Sleep(3 * time.Second)
+		clk.Sleep(3 * time.Second)
 		c2 <- "foo"
 	}()
 
@@ -153,14 +155,14 @@ func basicSelect() {
 
 }
 
-func timeOutToPreventBlocking() {
+func timeOutToPreventBlocking(clk clock.Clock) {
 	var c <-chan int
 	fmt.Println("############## timeOutToPreventBlocking ##############")
 
 	select {
 	case <-c:
 		// do something
-	case <-time.After(2 * time.Second):
+	case <-clk.After(2 * time.Second):
 		fmt.Println("timed out!!")
 	}
 	fmt.Println("##############################")
@@ -187,8 +189,8 @@ func main() {
 	signallingMechanism()
 	bufferedChan()
 	producerConsumerPattern()
-	basicSelect()
-	timeOutToPreventBlocking()
+	basicSelect(clock.Real())
+	timeOutToPreventBlocking(clock.Real())
 	foreverBlocking()
 
 }
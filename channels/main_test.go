@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// returnsAfter runs f against a fake clock and checks that it returns
+// once the clock reaches d, and not a moment before.
+func returnsAfter(t *testing.T, d time.Duration, f func(clock.Clock)) {
+	t.Helper()
+	clk := clock.NewFake(epoch)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f(clk)
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(d - time.Millisecond)
+	select {
+	case <-done:
+		t.Fatalf("returned after %v, want %v", clk.Since(epoch), d)
+	default:
+	}
+	clk.Advance(time.Millisecond)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("did not return after %v", d)
+	}
+}
+
+func TestBasicSelect(t *testing.T) {
+	returnsAfter(t, 3*time.Second, basicSelect)
+}
+
+func TestTimeOutToPreventBlocking(t *testing.T) {
+	returnsAfter(t, 2*time.Second, timeOutToPreventBlocking)
+}
@@ -0,0 +1,189 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a Clock whose time only moves when Advance or Set is called.
+// Timers and tickers created from it fire synchronously inside Advance, in
+// deadline order, with Now reporting each deadline as it fires.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+	// changed is broadcast whenever a waiter is added or removed, for
+	// BlockUntil.
+	changed *sync.Cond
+}
+
+// fakeWaiter backs both fake timers and fake tickers; period is zero for
+// a timer.
+type fakeWaiter struct {
+	clock  *Fake
+	until  time.Time
+	period time.Duration
+	ch     chan time.Time
+}
+
+// NewFake returns a Fake clock reading start.
+func NewFake(start time.Time) *Fake {
+	f := &Fake{now: start}
+	f.changed = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+// Sleep blocks until the clock has been advanced by at least d.
+func (f *Fake) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return f.addWaiter(d, 0)
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return fakeTicker{f.addWaiter(d, d)}
+}
+
+func (f *Fake) addWaiter(d, period time.Duration) *fakeWaiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w := &fakeWaiter{
+		clock:  f,
+		until:  f.now.Add(d),
+		period: period,
+		// Like the time package, a slow receiver misses ticks instead of
+		// blocking the clock.
+		ch: make(chan time.Time, 1),
+	}
+	if d <= 0 && period == 0 {
+		w.ch <- f.now
+		return w
+	}
+	f.waiters = append(f.waiters, w)
+	f.changed.Broadcast()
+	return w
+}
+
+// removeWaiter must be called with mu held.
+func (f *Fake) removeWaiter(w *fakeWaiter) bool {
+	for i, other := range f.waiters {
+		if other == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			f.changed.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the clock forward by d, firing every timer and ticker
+// that comes due on the way.
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the clock to t. Moving it backwards fires nothing.
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for {
+		sort.Slice(f.waiters, func(i, j int) bool {
+			return f.waiters[i].until.Before(f.waiters[j].until)
+		})
+		if len(f.waiters) == 0 || f.waiters[0].until.After(t) {
+			break
+		}
+
+		w := f.waiters[0]
+		if w.until.After(f.now) {
+			f.now = w.until
+		}
+		select {
+		case w.ch <- f.now:
+		default:
+		}
+
+		if w.period > 0 {
+			w.until = w.until.Add(w.period)
+		} else {
+			f.removeWaiter(w)
+		}
+	}
+	f.now = t
+}
+
+// BlockUntil blocks until at least n timers or tickers are pending. Tests
+// use it to make sure the code under test has started waiting before the
+// clock is advanced.
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.waiters) < n {
+		f.changed.Wait()
+	}
+}
+
+func (w *fakeWaiter) C() <-chan time.Time {
+	return w.ch
+}
+
+func (w *fakeWaiter) Stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	return w.clock.removeWaiter(w)
+}
+
+func (w *fakeWaiter) Reset(d time.Duration) bool {
+	f := w.clock
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	active := f.removeWaiter(w)
+	w.until = f.now.Add(d)
+	if w.period > 0 {
+		if d <= 0 {
+			panic("clock: non-positive interval for Ticker.Reset")
+		}
+		w.period = d
+	} else if d <= 0 {
+		// Fire right away, as NewTimer does for the same d.
+		select {
+		case w.ch <- f.now:
+		default:
+		}
+		return active
+	}
+	f.waiters = append(f.waiters, w)
+	f.changed.Broadcast()
+	return active
+}
+
+type fakeTicker struct {
+	*fakeWaiter
+}
+
+func (t fakeTicker) Stop()                 { t.fakeWaiter.Stop() }
+func (t fakeTicker) Reset(d time.Duration) { t.fakeWaiter.Reset(d) }
@@ -0,0 +1,219 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// recv returns the value waiting on ch, or fails if there is none.
+func recv(t *testing.T, ch <-chan time.Time) time.Time {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	default:
+		t.Fatal("nothing to receive")
+		return time.Time{}
+	}
+}
+
+func empty(t *testing.T, ch <-chan time.Time) {
+	t.Helper()
+	select {
+	case v := <-ch:
+		t.Fatalf("received %v, want nothing", v)
+	default:
+	}
+}
+
+func TestFakeAdvanceOrder(t *testing.T) {
+	f := NewFake(epoch)
+
+	// Created out of order, they must fire in deadline order, each seeing
+	// Now at its own deadline.
+	late := f.NewTimer(3 * time.Second)
+	early := f.NewTimer(time.Second)
+	mid := f.NewTimer(2 * time.Second)
+
+	f.Advance(1500 * time.Millisecond)
+	if got := recv(t, early.C()); !got.Equal(epoch.Add(time.Second)) {
+		t.Fatalf("early fired at %v, want %v", got, epoch.Add(time.Second))
+	}
+	empty(t, mid.C())
+	empty(t, late.C())
+	if got := f.Now(); !got.Equal(epoch.Add(1500 * time.Millisecond)) {
+		t.Fatalf("Now = %v after Advance, want %v", got, epoch.Add(1500*time.Millisecond))
+	}
+
+	f.Advance(time.Hour)
+	if got := recv(t, mid.C()); !got.Equal(epoch.Add(2 * time.Second)) {
+		t.Fatalf("mid fired at %v, want %v", got, epoch.Add(2*time.Second))
+	}
+	if got := recv(t, late.C()); !got.Equal(epoch.Add(3 * time.Second)) {
+		t.Fatalf("late fired at %v, want %v", got, epoch.Add(3*time.Second))
+	}
+}
+
+func TestFakeTimerNonPositive(t *testing.T) {
+	f := NewFake(epoch)
+	if got := recv(t, f.NewTimer(0).C()); !got.Equal(epoch) {
+		t.Fatalf("zero timer fired at %v, want %v", got, epoch)
+	}
+	if got := recv(t, f.After(-time.Second)); !got.Equal(epoch) {
+		t.Fatalf("negative After fired at %v, want %v", got, epoch)
+	}
+}
+
+func TestFakeResetNonPositive(t *testing.T) {
+	f := NewFake(epoch)
+
+	// Reset to zero or less fires right away, just like NewTimer(0).
+	timer := f.NewTimer(time.Second)
+	if !timer.Reset(0) {
+		t.Fatal("Reset of a pending timer = false, want true")
+	}
+	if got := recv(t, timer.C()); !got.Equal(epoch) {
+		t.Fatalf("timer reset to 0 fired at %v, want %v", got, epoch)
+	}
+	if timer.Reset(-time.Second) {
+		t.Fatal("Reset of a fired timer = true, want false")
+	}
+	if got := recv(t, timer.C()); !got.Equal(epoch) {
+		t.Fatalf("timer reset to -1s fired at %v, want %v", got, epoch)
+	}
+
+	// Nothing is left pending to fire a second time.
+	if timer.Stop() {
+		t.Fatal("Stop after an immediate Reset = true, want false")
+	}
+	f.Advance(time.Hour)
+	empty(t, timer.C())
+}
+
+func TestFakeTickerRearms(t *testing.T) {
+	f := NewFake(epoch)
+	tk := f.NewTicker(time.Second)
+	defer tk.Stop()
+
+	for i := 1; i <= 3; i++ {
+		f.Advance(time.Second)
+		if got := recv(t, tk.C()); !got.Equal(epoch.Add(time.Duration(i) * time.Second)) {
+			t.Fatalf("tick %d at %v, want %v", i, got, epoch.Add(time.Duration(i)*time.Second))
+		}
+	}
+
+	// Like time.Ticker, ticks nobody takes are dropped, not queued.
+	f.Advance(5 * time.Second)
+	if got := recv(t, tk.C()); !got.Equal(epoch.Add(4 * time.Second)) {
+		t.Fatalf("first missed tick at %v, want %v", got, epoch.Add(4*time.Second))
+	}
+	empty(t, tk.C())
+
+	// The ticker stays on its schedule after a long Advance.
+	f.Advance(time.Second)
+	if got := recv(t, tk.C()); !got.Equal(epoch.Add(9 * time.Second)) {
+		t.Fatalf("tick at %v, want %v", got, epoch.Add(9*time.Second))
+	}
+}
+
+func TestFakeStopReset(t *testing.T) {
+	f := NewFake(epoch)
+
+	timer := f.NewTimer(time.Second)
+	if !timer.Stop() {
+		t.Fatal("Stop on a pending timer = false, want true")
+	}
+	if timer.Stop() {
+		t.Fatal("second Stop = true, want false")
+	}
+	f.Advance(time.Hour)
+	empty(t, timer.C())
+
+	// Reset re-arms a stopped timer relative to the current time.
+	if timer.Reset(time.Second) {
+		t.Fatal("Reset of a stopped timer = true, want false")
+	}
+	if !timer.Reset(2 * time.Second) {
+		t.Fatal("Reset of a pending timer = false, want true")
+	}
+	f.Advance(time.Second)
+	empty(t, timer.C())
+	f.Advance(time.Second)
+	if got := recv(t, timer.C()); !got.Equal(epoch.Add(time.Hour + 2*time.Second)) {
+		t.Fatalf("reset timer fired at %v, want %v", got, epoch.Add(time.Hour+2*time.Second))
+	}
+
+	tk := f.NewTicker(time.Second)
+	tk.Reset(3 * time.Second)
+	f.Advance(2 * time.Second)
+	empty(t, tk.C())
+	f.Advance(time.Second)
+	recv(t, tk.C())
+	f.Advance(3 * time.Second)
+	recv(t, tk.C())
+
+	tk.Stop()
+	f.Advance(time.Hour)
+	empty(t, tk.C())
+}
+
+func TestFakeBlockUntil(t *testing.T) {
+	f := NewFake(epoch)
+
+	blocked := make(chan struct{})
+	go func() {
+		f.BlockUntil(2)
+		close(blocked)
+	}()
+
+	timer := f.NewTimer(time.Second)
+	select {
+	case <-blocked:
+		t.Fatal("BlockUntil(2) returned with one timer pending")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	f.NewTicker(time.Second)
+	select {
+	case <-blocked:
+	case <-time.After(time.Second):
+		t.Fatal("BlockUntil(2) still blocked with two waiters pending")
+	}
+
+	// Fired timers no longer count; tickers keep counting.
+	f.Advance(time.Second)
+	recv(t, timer.C())
+	done := make(chan struct{})
+	go func() {
+		f.BlockUntil(1)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("BlockUntil(1) blocked with a ticker pending")
+	}
+}
+
+func TestFakeSleep(t *testing.T) {
+	f := NewFake(epoch)
+
+	done := make(chan struct{})
+	go func() {
+		f.Sleep(time.Second)
+		close(done)
+	}()
+	f.BlockUntil(1)
+	f.Advance(time.Second)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Sleep did not return after the clock advanced")
+	}
+	if got := f.Since(epoch); got != time.Second {
+		t.Fatalf("Since(start) = %v, want 1s", got)
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/VarthanV/go-concurrency-exercises/clock
+
+go 1.22.6
@@ -2,10 +2,19 @@ module github.com/VarthanV/concurrency-exercises/faninout
 
 go 1.22.6
 
-require github.com/fatih/color v1.18.0
+require (
+	github.com/VarthanV/go-concurrency-exercises/clock v0.0.0
+	github.com/VarthanV/go-concurrency-exercises/ratelimit v0.0.0
+	github.com/fatih/color v1.18.0
+)
 
 require (
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	golang.org/x/sys v0.25.0 // indirect
 )
+
+replace (
+	github.com/VarthanV/go-concurrency-exercises/clock => ../clock
+	github.com/VarthanV/go-concurrency-exercises/ratelimit => ../ratelimit
+)
@@ -9,6 +9,8 @@ import (
 	"net/http"
 	"sync"
 
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+	"github.com/VarthanV/go-concurrency-exercises/ratelimit"
 	"github.com/fatih/color"
 )
 
@@ -25,9 +27,18 @@ type Result struct {
 	Error    error
 }
 
+// fanOutRate caps the requests per second all fanOut workers make
+// together, however many of them there are.
+const fanOutRate = 4
+
 func fanOut() {
 	var wg sync.WaitGroup
 
+	// The workers share one client, so they share its limiter too.
+	client := &http.Client{
+		Transport: &ratelimit.Transport{Limiter: ratelimit.NewBucket(fanOutRate, 1, clock.Real())},
+	}
+
 	makeRequest := func(id int, ctx context.Context, wg *sync.WaitGroup, inputStream <-chan string, outStream chan<- Result) {
 		defer wg.Done()
 
@@ -56,7 +67,7 @@ func fanOut() {
 					continue
 				}
 
-				res, err := http.DefaultClient.Do(req)
+				res, err := client.Do(req)
 				if err != nil {
 					log.Println("error in doing request ", err)
 					result.Error = err
@@ -3,9 +3,11 @@ package main
 import (
 	"fmt"
 	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
 )
 
-func sendingIterationVariablesOnChannel() {
+func sendingIterationVariablesOnChannel(clk clock.Clock) {
 	fmt.Println("############## sendingIterationVariablesOnChannel ##############")
 	stream := make(chan string)
 
@@ -20,7 +22,7 @@ func sendingIterationVariablesOnChannel() {
 		case val := <-stream:
 			fmt.Println("val received is ", val)
 
-		case <-time.After(2 * time.Second):
+		case <-clk.After(2 * time.Second):
 			fmt.Println("Timeout!!")
 			fmt.Println("#########################################")
 			return
@@ -29,7 +31,7 @@ func sendingIterationVariablesOnChannel() {
 
 }
 
-func infiniteWaitUntilStopped() {
+func infiniteWaitUntilStopped(clk clock.Clock) {
 	fmt.Println("############## sendingIterationVariablesOnChannel ##############")
 
 	done := make(chan bool)
@@ -39,7 +41,7 @@ func infiniteWaitUntilStopped() {
 	}()
 
 	go func() {
-		time.Sleep(5 * time.Second)
+		clk.Sleep(5 * time.Second)
 		done <- true
 	}()
 
@@ -53,6 +55,6 @@ func infiniteWaitUntilStopped() {
 	}
 }
 func main() {
-	sendingIterationVariablesOnChannel()
-	infiniteWaitUntilStopped()
+	sendingIterationVariablesOnChannel(clock.Real())
+	infiniteWaitUntilStopped(clock.Real())
 }
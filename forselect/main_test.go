@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// discardStdout silences the exercise's printing for the rest of the test;
+// infiniteWaitUntilStopped prints in a busy loop.
+func discardStdout(t *testing.T) {
+	t.Helper()
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	t.Cleanup(func() {
+		os.Stdout = stdout
+		devNull.Close()
+	})
+}
+
+func TestSendingIterationVariablesTimesOut(t *testing.T) {
+	discardStdout(t)
+	clk := clock.NewFake(epoch)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sendingIterationVariablesOnChannel(clk)
+	}()
+
+	// Every pass through the select starts a fresh 2s timeout, so once
+	// all 8 values are in there are 9 pending and only the last matters.
+	clk.BlockUntil(9)
+	select {
+	case <-done:
+		t.Fatal("returned before the timeout")
+	default:
+	}
+	clk.Advance(2 * time.Second)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not return after the timeout")
+	}
+}
+
+func TestInfiniteWaitUntilStopped(t *testing.T) {
+	discardStdout(t)
+	clk := clock.NewFake(epoch)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		infiniteWaitUntilStopped(clk)
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(5*time.Second - time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("returned before the 5s sleep was over")
+	default:
+	}
+	clk.Advance(time.Millisecond)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not return once the sleep was over")
+	}
+}
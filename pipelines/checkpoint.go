@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/pipelines/pipeline"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type urlStatus string
+
+const (
+	statusPending urlStatus = "pending"
+	statusFetched urlStatus = "fetched"
+	statusStored  urlStatus = "stored"
+	statusFailed  urlStatus = "failed"
+)
+
+// URLCheckpoint records how far the scraper got with one URL, so that a
+// rerun after a crash can pick up where the last run stopped.
+type URLCheckpoint struct {
+	URL    string    `gorm:"primaryKey"`
+	Status urlStatus `gorm:"index"`
+	// Attempts counts fetch attempts across all runs.
+	Attempts  int
+	LastError string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// SinkDelivery records that a sink holds the todo fetched from URL, so
+// that a rerun does not write it there a second time. The sqlite sink
+// ignores duplicates anyway, but the file sinks only ever append.
+type SinkDelivery struct {
+	URL       string `gorm:"primaryKey"`
+	Sink      string `gorm:"primaryKey"`
+	CreatedAt time.Time
+}
+
+// checkpointStore keeps URLCheckpoint rows in the scraper's database.
+type checkpointStore struct {
+	db *gorm.DB
+	// maxAttempts is how many fetch attempts a failed URL gets in total
+	// before reruns stop trying it.
+	maxAttempts int
+}
+
+func newCheckpointStore(db *gorm.DB, maxAttempts int) (*checkpointStore, error) {
+	if err := db.AutoMigrate(&URLCheckpoint{}, &SinkDelivery{}); err != nil {
+		return nil, err
+	}
+	return &checkpointStore{db: db, maxAttempts: maxAttempts}, nil
+}
+
+// resume registers urls as pending if they are new and returns the ones
+// that still need work: everything except stored URLs and failed URLs that
+// are out of attempts.
+func (c *checkpointStore) resume(ctx context.Context, urls []string) ([]string, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+
+	rows := make([]URLCheckpoint, 0, len(urls))
+	for _, u := range urls {
+		rows = append(rows, URLCheckpoint{URL: u, Status: statusPending})
+	}
+	err := c.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var done []string
+	err = c.db.WithContext(ctx).Model(&URLCheckpoint{}).
+		Where("url IN ?", urls).
+		Where("status = ? OR (status = ? AND attempts >= ?)", statusStored, statusFailed, c.maxAttempts).
+		Pluck("url", &done).Error
+	if err != nil {
+		return nil, err
+	}
+
+	skip := make(map[string]bool, len(done))
+	for _, u := range done {
+		skip[u] = true
+	}
+
+	todo := make([]string, 0, len(urls)-len(done))
+	for _, u := range urls {
+		if !skip[u] {
+			todo = append(todo, u)
+		}
+	}
+	return todo, nil
+}
+
+const (
+	// resumeChunk is how many URLs pending registers in one go.
+	resumeChunk = 100
+	// resumeMaxLatency is how long a URL waits for its chunk to fill up
+	// before pending registers the chunk anyway, so that URLs typed on
+	// stdin one by one do not sit there until 100 have arrived.
+	resumeMaxLatency = 200 * time.Millisecond
+)
+
+// pending passes on the URLs from src that still need work, registering
+// them in chunks as they come in; see resume. A chunk is registered once
+// it is full or its first URL has waited resumeMaxLatency.
+func (c *checkpointStore) pending(src pipeline.Source[string]) pipeline.Source[string] {
+	return func(ctx context.Context, emit func(string) bool) error {
+		ctx, cancel := context.WithCancel(ctx)
+
+		var (
+			urls   = make(chan string)
+			srcErr = make(chan error, 1)
+		)
+		go func() {
+			defer close(urls)
+			srcErr <- src(ctx, func(u string) bool {
+				select {
+				case urls <- u:
+					return true
+				case <-ctx.Done():
+					return false
+				}
+			})
+		}()
+		// src has to be gone before we return, or it would outlive the
+		// pipeline stage that started it.
+		defer func() {
+			cancel()
+			for range urls {
+			}
+		}()
+
+		var (
+			chunk    []string
+			err      error
+			deadline <-chan time.Time
+		)
+
+		flush := func() bool {
+			deadline = nil
+			todo, rerr := c.resume(ctx, chunk)
+			chunk = chunk[:0]
+			if rerr != nil {
+				if ctx.Err() == nil {
+					err = rerr
+				}
+				return false
+			}
+			for _, u := range todo {
+				if !emit(u) {
+					return false
+				}
+			}
+			return true
+		}
+
+		for {
+			select {
+			case u, ok := <-urls:
+				if !ok {
+					// Whatever src managed to emit before it failed is
+					// still worth fetching.
+					serr := <-srcErr
+					if len(chunk) > 0 {
+						flush()
+					}
+					return errors.Join(serr, err)
+				}
+				if len(chunk) == 0 {
+					deadline = time.After(resumeMaxLatency)
+				}
+				chunk = append(chunk, u)
+				if len(chunk) >= resumeChunk && !flush() {
+					return err
+				}
+			case <-deadline:
+				if !flush() {
+					return err
+				}
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+// reset puts urls back to pending with no attempts.
+func (c *checkpointStore) reset(ctx context.Context, urls []string) error {
+	if len(urls) == 0 {
+		return nil
+	}
+	return c.db.WithContext(ctx).Model(&URLCheckpoint{}).
+		Where("url IN ?", urls).
+		Updates(map[string]any{
+			"status":     statusPending,
+			"attempts":   0,
+			"last_error": "",
+		}).Error
+}
+
+func (c *checkpointStore) update(ctx context.Context, url string, values map[string]any) error {
+	return c.db.WithContext(ctx).Model(&URLCheckpoint{}).
+		Where("url = ?", url).
+		Updates(values).Error
+}
+
+func (c *checkpointStore) markFetched(ctx context.Context, url string, attempts int) error {
+	return c.update(ctx, url, map[string]any{
+		"status":     statusFetched,
+		"attempts":   gorm.Expr("attempts + ?", attempts),
+		"last_error": "",
+	})
+}
+
+func (c *checkpointStore) markStored(ctx context.Context, url string) error {
+	return c.update(ctx, url, map[string]any{"status": statusStored})
+}
+
+// delivered returns which of urls sink already holds.
+func (c *checkpointStore) delivered(ctx context.Context, sink string, urls []string) (map[string]bool, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+
+	var held []string
+	err := c.db.WithContext(ctx).Model(&SinkDelivery{}).
+		Where("sink = ? AND url IN ?", sink, urls).
+		Pluck("url", &held).Error
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(map[string]bool, len(held))
+	for _, u := range held {
+		done[u] = true
+	}
+	return done, nil
+}
+
+// markDelivered records that sink holds urls.
+func (c *checkpointStore) markDelivered(ctx context.Context, sink string, urls []string) error {
+	if len(urls) == 0 {
+		return nil
+	}
+
+	rows := make([]SinkDelivery, 0, len(urls))
+	for _, u := range urls {
+		rows = append(rows, SinkDelivery{URL: u, Sink: sink})
+	}
+	return c.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&rows).Error
+}
+
+// markFailed records err. attempts is the number of fetch attempts made in
+// this run, zero if the failure happened after fetching.
+func (c *checkpointStore) markFailed(ctx context.Context, url string, attempts int, err error) error {
+	return c.update(ctx, url, map[string]any{
+		"status":     statusFailed,
+		"attempts":   gorm.Expr("attempts + ?", attempts),
+		"last_error": err.Error(),
+	})
+}
+
+// fetchStage wraps doHTTP so that every URL's outcome is checkpointed.
+func (c *checkpointStore) fetchStage(fetch pipeline.Stage[string, *fetchResult]) pipeline.Stage[string, *fetchResult] {
+	return func(ctx context.Context, url string) (*fetchResult, error) {
+		res, err := fetch(ctx, url)
+		// Record the outcome even if the run is being cancelled.
+		saveCtx := context.WithoutCancel(ctx)
+		if err != nil {
+			attempts := 1
+			var ae *attemptsError
+			if errors.As(err, &ae) {
+				attempts = ae.attempts
+			}
+			return nil, errors.Join(err, c.markFailed(saveCtx, url, attempts, err))
+		}
+		return res, c.markFetched(saveCtx, url, res.Attempts)
+	}
+}
+
+// storeStage wraps insertInDB so that stored and failed items are
+// checkpointed.
+func (c *checkpointStore) storeStage(store pipeline.BatchStage[*fetchResult, *fetchResult]) pipeline.BatchStage[*fetchResult, *fetchResult] {
+	return func(ctx context.Context, batch []*fetchResult) ([]*fetchResult, []error) {
+		results, errs := store(ctx, batch)
+
+		saveCtx := context.WithoutCancel(ctx)
+		for i, res := range batch {
+			var err error
+			if errs[i] != nil {
+				err = c.markFailed(saveCtx, res.URL, 0, errs[i])
+			} else {
+				err = c.markStored(saveCtx, res.URL)
+			}
+			errs[i] = errors.Join(errs[i], err)
+		}
+		return results, errs
+	}
+}
+
+// progress counts URLs by status.
+func (c *checkpointStore) progress(ctx context.Context) (map[urlStatus]int64, error) {
+	var rows []struct {
+		Status urlStatus
+		Count  int64
+	}
+	err := c.db.WithContext(ctx).Model(&URLCheckpoint{}).
+		Select("status, count(*) as count").
+		Group("status").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[urlStatus]int64, len(rows))
+	for _, r := range rows {
+		counts[r.Status] = r.Count
+	}
+	return counts, nil
+}
+
+func (c *checkpointStore) writeProgress(ctx context.Context, w io.Writer) error {
+	counts, err := c.progress(ctx)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, s := range []urlStatus{statusPending, statusFetched, statusStored, statusFailed} {
+		fmt.Fprintf(tw, "%s\t%d\n", s, counts[s])
+		total += counts[s]
+	}
+	fmt.Fprintf(tw, "total\t%d\n", total)
+	return tw.Flush()
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestPendingFlushesSlowSource feeds pending one URL and then nothing, like
+// a user typing on stdin: the URL has to come out without 99 more behind it.
+func TestPendingFlushesSlowSource(t *testing.T) {
+	store, err := newCheckpointStore(openTestDB(t), maxFetchAttempts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := func(ctx context.Context, emit func(string) bool) error {
+		if !emit("https://example.com/todos/1") {
+			return nil
+		}
+		<-ctx.Done()
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	got := make(chan string, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- store.pending(src)(ctx, func(u string) bool {
+			got <- u
+			return true
+		})
+	}()
+
+	select {
+	case u := <-got:
+		if u != "https://example.com/todos/1" {
+			t.Fatalf("pending emitted %q", u)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending held back a URL while the source was idle")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("pending = %v after cancel", err)
+	}
+}
+
+func TestPendingSkipsStored(t *testing.T) {
+	store, err := newCheckpointStore(openTestDB(t), maxFetchAttempts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	var urls []string
+	for i := 1; i <= resumeChunk+5; i++ {
+		urls = append(urls, fmt.Sprintf("https://example.com/todos/%d", i))
+	}
+	if _, err := store.resume(ctx, urls[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.markStored(ctx, urls[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := emitted(t, ctx, store.pending(generator(urls...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := slices.Delete(slices.Clone(urls), 1, 2)
+	if !slices.Equal(got, want) {
+		t.Fatalf("pending emitted %d URLs, want all %d but the stored one", len(got), len(want))
+	}
+}
+
+// TestResumeSkipsExhaustedFailures covers a URL that kept failing: once
+// its attempts across runs reach maxAttempts, reruns leave it alone.
+func TestResumeSkipsExhaustedFailures(t *testing.T) {
+	store, err := newCheckpointStore(openTestDB(t), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	urls := []string{
+		"https://example.com/todos/1",
+		"https://example.com/todos/2",
+		"https://example.com/todos/3",
+	}
+	if _, err := store.resume(ctx, urls); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first run gives up on todos/1 after all three attempts and on
+	// todos/2 after two.
+	failure := errors.New("unexpected status 503")
+	if err := store.markFailed(ctx, urls[0], 3, failure); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.markFailed(ctx, urls[1], 2, failure); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := emitted(t, ctx, store.pending(generator(urls...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := urls[1:]; !slices.Equal(got, want) {
+		t.Fatalf("second run got %q, want %q", got, want)
+	}
+
+	// One more failed attempt uses up todos/2 as well.
+	if err := store.markFailed(ctx, urls[1], 1, failure); err != nil {
+		t.Fatal(err)
+	}
+	got, err = emitted(t, ctx, store.pending(generator(urls...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := urls[2:]; !slices.Equal(got, want) {
+		t.Fatalf("third run got %q, want %q", got, want)
+	}
+
+	// reset gives them a fresh set of attempts.
+	if err := store.reset(ctx, urls[:2]); err != nil {
+		t.Fatal(err)
+	}
+	got, err = emitted(t, ctx, store.pending(generator(urls...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, urls) {
+		t.Fatalf("run after reset got %q, want %q", got, urls)
+	}
+}
+
+// TestPendingFlushesOnSourceError checks that URLs a source emitted before
+// failing are still passed on along with its error.
+func TestPendingFlushesOnSourceError(t *testing.T) {
+	store, err := newCheckpointStore(openTestDB(t), maxFetchAttempts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	urls := []string{"https://example.com/todos/1", "https://example.com/todos/2"}
+	srcErr := errors.New("listing went away")
+	src := func(ctx context.Context, emit func(string) bool) error {
+		for _, u := range urls {
+			if !emit(u) {
+				return nil
+			}
+		}
+		return srcErr
+	}
+
+	got, err := emitted(t, context.Background(), store.pending(src))
+	if !errors.Is(err, srcErr) {
+		t.Fatalf("pending = %v, want the source error", err)
+	}
+	if !slices.Equal(got, urls) {
+		t.Fatalf("pending emitted %q, want %q before the error", got, urls)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DeadLetter is a pipeline item that failed for good, kept so it can be
+// inspected and pushed through the pipeline again.
+type DeadLetter struct {
+	ID    uint   `gorm:"primaryKey"`
+	Stage string `gorm:"index"`
+	// URL is the URL the item was scraped from, used to re-enqueue it.
+	URL string `gorm:"index"`
+	// Item is the failed item as JSON.
+	Item string
+	// Errors is a JSON array holding every error of the chain, with
+	// errors.Join results taken apart.
+	Errors     string
+	Attempts   int
+	CreatedAt  time.Time
+	RequeuedAt *time.Time
+}
+
+type deadLetterStore struct {
+	db *gorm.DB
+}
+
+func newDeadLetterStore(db *gorm.DB) (*deadLetterStore, error) {
+	if err := db.AutoMigrate(&DeadLetter{}); err != nil {
+		return nil, err
+	}
+	return &deadLetterStore{db: db}, nil
+}
+
+// stageFailure is implemented by every *pipeline.StageError[T].
+type stageFailure interface {
+	error
+	StageName() string
+	Input() any
+}
+
+// flattenErrors walks err's chain and returns one message per link,
+// outermost first. Joined errors are split into their parts instead of
+// being kept as a single multi-line message.
+func flattenErrors(err error) []string {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case interface{ Unwrap() []error }:
+		var msgs []string
+		for _, inner := range e.Unwrap() {
+			msgs = append(msgs, flattenErrors(inner)...)
+		}
+		return msgs
+	case interface{ Unwrap() error }:
+		return append([]string{err.Error()}, flattenErrors(e.Unwrap())...)
+	}
+	return []string{err.Error()}
+}
+
+// itemURL finds the URL an item came from.
+func itemURL(item any) string {
+	switch v := item.(type) {
+	case string:
+		return v
+	case *fetchResult:
+		if v != nil {
+			return v.URL
+		}
+	}
+	return ""
+}
+
+// record stores err as a dead letter.
+func (d *deadLetterStore) record(ctx context.Context, err error) error {
+	letter := DeadLetter{Stage: "unknown", Attempts: 1}
+
+	var sf stageFailure
+	cause := err
+	if errors.As(err, &sf) {
+		letter.Stage = sf.StageName()
+		letter.URL = itemURL(sf.Input())
+		cause = errors.Unwrap(sf)
+
+		item, merr := json.Marshal(sf.Input())
+		if merr != nil {
+			return merr
+		}
+		letter.Item = string(item)
+	}
+
+	var ae *attemptsError
+	if errors.As(err, &ae) {
+		letter.Attempts = ae.attempts
+	}
+
+	chain, merr := json.Marshal(flattenErrors(cause))
+	if merr != nil {
+		return merr
+	}
+	letter.Errors = string(chain)
+
+	return d.db.WithContext(ctx).Create(&letter).Error
+}
+
+// tee records every error from errs as a dead letter and passes it on.
+func (d *deadLetterStore) tee(ctx context.Context, errs <-chan error) <-chan error {
+	out := make(chan error)
+
+	go func() {
+		defer close(out)
+		for err := range errs {
+			if derr := d.record(context.WithoutCancel(ctx), err); derr != nil {
+				log.Println("unable to record dead letter ", derr)
+			}
+			out <- err
+		}
+	}()
+
+	return out
+}
+
+// list returns dead letters, oldest first. Unless all is set, letters
+// that were already re-enqueued are left out.
+func (d *deadLetterStore) list(ctx context.Context, all bool) ([]DeadLetter, error) {
+	var letters []DeadLetter
+	q := d.db.WithContext(ctx).Order("id")
+	if !all {
+		q = q.Where("requeued_at IS NULL")
+	}
+	return letters, q.Find(&letters).Error
+}
+
+// takeForRequeue marks the given dead letters, or every pending one if ids
+// is empty, as re-enqueued and returns them. Letters without a URL, such
+// as a failure of the URL source itself, cannot be re-enqueued: they are
+// left pending and only counted.
+func (d *deadLetterStore) takeForRequeue(ctx context.Context, ids []uint) ([]DeadLetter, int64, error) {
+	var (
+		letters []DeadLetter
+		skipped int64
+	)
+
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		pending := func() *gorm.DB {
+			q := tx.Model(&DeadLetter{}).Where("requeued_at IS NULL")
+			if len(ids) > 0 {
+				q = q.Where("id IN ?", ids)
+			}
+			return q
+		}
+
+		if err := pending().Where("url = ''").Count(&skipped).Error; err != nil {
+			return err
+		}
+		if err := pending().Where("url <> ''").Find(&letters).Error; err != nil {
+			return err
+		}
+		if len(letters) == 0 {
+			return nil
+		}
+
+		taken := make([]uint, 0, len(letters))
+		for _, l := range letters {
+			taken = append(taken, l.ID)
+		}
+		return tx.Model(&DeadLetter{}).
+			Where("id IN ?", taken).
+			Update("requeued_at", time.Now()).Error
+	})
+	return letters, skipped, err
+}
+
+func writeDeadLetters(w io.Writer, letters []DeadLetter) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTAGE\tURL\tATTEMPTS\tFAILED AT\tREQUEUED\tERRORS")
+	for _, l := range letters {
+		requeued := "-"
+		if l.RequeuedAt != nil {
+			requeued = l.RequeuedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			l.ID, l.Stage, l.URL, l.Attempts, l.CreatedAt.Format(time.RFC3339), requeued, l.Errors)
+	}
+	return tw.Flush()
+}
+
+// deadLetterCommand implements
+//
+//	deadletters list [-all]
+//	deadletters requeue [id...]
+func deadLetterCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: deadletters list [-all] | deadletters requeue [id...]")
+	}
+
+	s, err := openScraper(scraperDBPath)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	ctx, cancel := scraperContext()
+	defer cancel()
+
+	switch args[0] {
+	case "list":
+		fs := flag.NewFlagSet("deadletters list", flag.ContinueOnError)
+		all := fs.Bool("all", false, "include dead letters that were already re-enqueued")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		letters, err := s.deadLetters.list(ctx, *all)
+		if err != nil {
+			return err
+		}
+		return writeDeadLetters(os.Stdout, letters)
+
+	case "requeue":
+		var ids []uint
+		for _, a := range args[1:] {
+			id, err := strconv.ParseUint(a, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid dead letter id %q", a)
+			}
+			ids = append(ids, uint(id))
+		}
+
+		letters, skipped, err := s.deadLetters.takeForRequeue(ctx, ids)
+		if err != nil {
+			return err
+		}
+		if skipped > 0 {
+			log.Printf("skipping %d dead letters without a URL; see deadletters list\n", skipped)
+		}
+
+		seen := make(map[string]bool)
+		var urls []string
+		for _, l := range letters {
+			if !seen[l.URL] {
+				seen[l.URL] = true
+				urls = append(urls, l.URL)
+			}
+		}
+		// Give the URLs a fresh start so the checkpoints do not skip them.
+		if err := s.checkpoints.reset(ctx, urls); err != nil {
+			return err
+		}
+
+		log.Printf("re-enqueueing %d dead letters (%d urls)\n", len(letters), len(urls))
+		s.run(ctx, generator(urls...))
+		return nil
+	}
+
+	return fmt.Errorf("unknown deadletters command %q", args[0])
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/VarthanV/go-concurrency-exercises/pipelines/pipeline"
+)
+
+func TestFlattenErrors(t *testing.T) {
+	errA := errors.New("a")
+	errB := errors.New("b")
+	err := &attemptsError{
+		attempts: 3,
+		err:      errors.Join(errA, fmt.Errorf("wrapped: %w", errB)),
+	}
+
+	got := flattenErrors(err)
+	want := []string{
+		"a\nwrapped: b (after 3 attempts)",
+		"a",
+		"wrapped: b",
+		"b",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("flattenErrors = %q, want %q", got, want)
+	}
+
+	if got := flattenErrors(nil); got != nil {
+		t.Fatalf("flattenErrors(nil) = %q, want nil", got)
+	}
+}
+
+func TestDeadLetterRequeue(t *testing.T) {
+	ctx := context.Background()
+	d, err := newDeadLetterStore(openTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failures := []error{
+		&pipeline.StageError[string]{Stage: "doHTTP", Item: "https://example.com/1",
+			Err: &attemptsError{attempts: 4, err: errors.New("boom")}},
+		// The URL source failing has no item, and so no URL.
+		&pipeline.StageError[any]{Stage: "generator", Err: errors.New("bad pattern")},
+		&pipeline.StageError[*fetchResult]{Stage: "store", Item: todoResult(2, "t"),
+			Err: errors.New("disk full")},
+	}
+	for _, f := range failures {
+		if err := d.record(ctx, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	letters, err := d.list(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 3 {
+		t.Fatalf("list = %d letters, want 3", len(letters))
+	}
+	if l := letters[0]; l.Stage != "doHTTP" || l.URL != "https://example.com/1" || l.Attempts != 4 {
+		t.Fatalf("first letter = %+v", l)
+	}
+
+	taken, skipped, err := d.takeForRequeue(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var urls []string
+	for _, l := range taken {
+		urls = append(urls, l.URL)
+	}
+	if want := []string{"https://example.com/1", "https://example.com/todos/2"}; !slices.Equal(urls, want) {
+		t.Fatalf("requeued %v, want %v", urls, want)
+	}
+	if skipped != 1 {
+		t.Fatalf("skipped %d letters, want the one without a URL", skipped)
+	}
+
+	// Only the letter that could not be re-enqueued is still pending.
+	pending, err := d.list(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Stage != "generator" || pending[0].RequeuedAt != nil {
+		t.Fatalf("pending after requeue = %+v, want only the generator letter", pending)
+	}
+	all, err := d.list(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].RequeuedAt == nil {
+		t.Fatalf("list -all = %+v, want 3 letters with the first requeued", all)
+	}
+
+	// A letter is taken once.
+	again, _, err := d.takeForRequeue(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 0 {
+		t.Fatalf("second requeue took %d letters, want 0", len(again))
+	}
+
+	// Asking for a specific id only looks at that one.
+	taken, skipped, err = d.takeForRequeue(ctx, []uint{pending[0].ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(taken) != 0 || skipped != 1 {
+		t.Fatalf("requeue of the generator letter took %d and skipped %d, want 0 and 1", len(taken), skipped)
+	}
+}
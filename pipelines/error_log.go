@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatingFile is a buffered, append-only file that is moved aside once
+// it grows past maxSize bytes or gets older than maxAge, keeping at most
+// maxBackups of the moved files. A zero limit disables that check. Every
+// Write is treated as one record and never split across files.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	file     *os.File
+	buf      *bufio.Writer
+	size     int64
+	openedAt time.Time
+}
+
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	// A file left behind by an earlier run counts from its last write.
+	if r.size > 0 && r.maxAge > 0 && time.Since(r.openedAt) >= r.maxAge {
+		if err := r.rotate(); err != nil {
+			r.file.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.file = f
+	r.buf = bufio.NewWriter(f)
+	r.size = info.Size()
+	r.openedAt = time.Now()
+	if r.size > 0 {
+		r.openedAt = info.ModTime()
+	}
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	tooBig := r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize
+	tooOld := r.maxAge > 0 && r.size > 0 && time.Since(r.openedAt) >= r.maxAge
+	if tooBig || tooOld {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.buf.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) Flush() error {
+	return r.buf.Flush()
+}
+
+// backupName turns errors.jsonl into errors-20240102T150405.000000000.jsonl,
+// adding a counter in the unlikely case that name is taken.
+func (r *rotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(r.path)
+	base := strings.TrimSuffix(r.path, ext) + "-" + t.Format("20060102T150405.000000000")
+	name := base + ext
+	for i := 1; ; i++ {
+		if _, err := os.Stat(name); errors.Is(err, os.ErrNotExist) {
+			return name
+		}
+		name = fmt.Sprintf("%s.%d%s", base, i, ext)
+	}
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(r.path, r.backupName(time.Now())); err != nil {
+		return err
+	}
+	if err := r.prune(); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+// prune removes the oldest backups beyond maxBackups. The timestamp in
+// the name sorts lexically, so the oldest come first. Only names that
+// backupName could have produced count, so that a file like
+// errors-old.jsonl that someone else put next to ours is never touched.
+func (r *rotatingFile) prune() error {
+	if r.maxBackups <= 0 {
+		return nil
+	}
+	ext := filepath.Ext(r.path)
+	base := strings.TrimSuffix(r.path, ext)
+	matches, err := filepath.Glob(base + "-*" + ext)
+	if err != nil {
+		return err
+	}
+	isBackup := regexp.MustCompile("^" + regexp.QuoteMeta(base) +
+		`-\d{8}T\d{6}\.\d{9}(\.\d+)?` + regexp.QuoteMeta(ext) + "$")
+
+	var backups []string
+	for _, name := range matches {
+		if isBackup.MatchString(name) {
+			backups = append(backups, name)
+		}
+	}
+	sort.Strings(backups)
+
+	var errs []error
+	for len(backups) > r.maxBackups {
+		errs = append(errs, os.Remove(backups[0]))
+		backups = backups[1:]
+	}
+	return errors.Join(errs...)
+}
+
+func (r *rotatingFile) Close() error {
+	return errors.Join(r.buf.Flush(), r.file.Close())
+}
+
+// errorLog writes pipeline errors to a rotatingFile as JSON lines.
+// Log only appends to an in-memory queue, so a slow disk never holds up
+// the pipeline; a background goroutine does the writing. Close waits until
+// everything queued so far is on disk.
+type errorLog struct {
+	file   *rotatingFile
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	queue    []error
+	isClosed bool
+	// wake is signalled whenever the queue goes from empty to non-empty.
+	wake chan struct{}
+	done chan struct{}
+}
+
+func newErrorLog(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*errorLog, error) {
+	file, err := openRotatingFile(path, maxSize, maxAge, maxBackups)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &errorLog{
+		file:   file,
+		logger: slog.New(slog.NewJSONHandler(file, nil)),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go l.writer()
+
+	return l, nil
+}
+
+// Log queues err to be written. Errors logged after Close are dropped.
+func (l *errorLog) Log(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.isClosed {
+		return
+	}
+	l.queue = append(l.queue, err)
+	if len(l.queue) == 1 {
+		select {
+		case l.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (l *errorLog) writer() {
+	defer close(l.done)
+
+	for {
+		<-l.wake
+
+		l.mu.Lock()
+		batch := l.queue
+		l.queue = nil
+		closed := l.isClosed
+		l.mu.Unlock()
+
+		for _, err := range batch {
+			l.write(err)
+		}
+		// Flush once per batch rather than per line, but never leave a
+		// batch sitting in the buffer while waiting for the next one.
+		if err := l.file.Flush(); err != nil {
+			fmt.Fprintln(os.Stderr, "unable to write error log ", err)
+		}
+
+		if closed {
+			return
+		}
+	}
+}
+
+func (l *errorLog) write(err error) {
+	var attrs []any
+
+	msg, cause := err.Error(), err
+	var sf stageFailure
+	if errors.As(err, &sf) {
+		msg, cause = "stage failed", errors.Unwrap(sf)
+		attrs = append(attrs,
+			slog.String("stage", sf.StageName()),
+			slog.String("url", itemURL(sf.Input())),
+		)
+	}
+	attrs = append(attrs, slog.Any("errors", flattenErrors(cause)))
+	var ae *attemptsError
+	if errors.As(err, &ae) {
+		attrs = append(attrs, slog.Int("attempts", ae.attempts))
+	}
+	if kind := errorKind(err); kind != "" {
+		attrs = append(attrs, slog.String("kind", kind))
+	}
+	var se *HTTPStatusError
+	if errors.As(err, &se) {
+		attrs = append(attrs, slog.Int("status", se.StatusCode))
+	}
+
+	l.logger.Error(msg, attrs...)
+}
+
+// Close writes out every queued error and closes the file. It is safe to
+// call Close more than once.
+func (l *errorLog) Close() error {
+	l.mu.Lock()
+	if l.isClosed {
+		l.mu.Unlock()
+		<-l.done
+		return nil
+	}
+	l.isClosed = true
+	l.mu.Unlock()
+
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+	<-l.done
+
+	return l.file.Close()
+}
+
+// logErrors consumes errs until it is closed, logging every error. It must
+// not stop early: the pipeline blocks until its error stream is drained.
+func (l *errorLog) logErrors(errs <-chan error) {
+	n := 0
+	for err := range errs {
+		l.Log(err)
+		n++
+	}
+	if n > 0 {
+		log.Printf("%d pipeline errors logged to %s\n", n, l.file.path)
+	}
+}
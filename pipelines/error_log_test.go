@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// backups returns the rotated copies of path, oldest first.
+func backups(t *testing.T, path string) []string {
+	t.Helper()
+	ext := filepath.Ext(path)
+	names, err := filepath.Glob(strings.TrimSuffix(path, ext) + "-2*" + ext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// record is a 10 byte line.
+func record(i int) []byte {
+	return []byte(fmt.Sprintf("record %02d\n", i))
+}
+
+func TestRotatingFileSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "errors.jsonl")
+	r, err := openRotatingFile(path, 25, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two records fit in 25 bytes, the third goes to a fresh file.
+	for i := range 3 {
+		if _, err := r.Write(record(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	old := backups(t, path)
+	if len(old) != 1 {
+		t.Fatalf("backups = %v, want 1", old)
+	}
+	if got := readFile(t, old[0]); got != "record 00\nrecord 01\n" {
+		t.Fatalf("backup holds %q, want the first two records", got)
+	}
+	if got := readFile(t, path); got != "record 02\n" {
+		t.Fatalf("current file holds %q, want the third record", got)
+	}
+}
+
+// TestRotatingFileOversizedRecord checks that a record larger than maxSize
+// is written whole rather than split or rotated forever.
+func TestRotatingFileOversizedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "errors.jsonl")
+	r, err := openRotatingFile(path, 5, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 2 {
+		if _, err := r.Write(record(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if old := backups(t, path); len(old) != 1 || readFile(t, old[0]) != "record 00\n" {
+		t.Fatalf("backups = %v, want one holding the first record", old)
+	}
+	if got := readFile(t, path); got != "record 01\n" {
+		t.Fatalf("current file holds %q, want the second record", got)
+	}
+}
+
+func TestRotatingFileAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "errors.jsonl")
+
+	// A file an earlier run left behind counts from its last write.
+	if err := os.WriteFile(path, record(0), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stale := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(path, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := openRotatingFile(path, 0, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old := backups(t, path); len(old) != 1 || readFile(t, old[0]) != string(record(0)) {
+		t.Fatalf("backups = %v after opening a stale file, want it moved aside", old)
+	}
+
+	if _, err := r.Write(record(1)); err != nil {
+		t.Fatal(err)
+	}
+	// A file that got old while open rotates on the next write.
+	r.openedAt = r.openedAt.Add(-2 * time.Hour)
+	if _, err := r.Write(record(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	old := backups(t, path)
+	if len(old) != 2 || readFile(t, old[1]) != string(record(1)) {
+		t.Fatalf("backups = %v, want a second one holding record 01", old)
+	}
+	if got := readFile(t, path); got != string(record(2)) {
+		t.Fatalf("current file holds %q, want record 02", got)
+	}
+}
+
+func TestRotatingFilePrune(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "errors.jsonl")
+
+	// Files that only look a bit like backups are not ours to delete.
+	foreign := []string{"errors-old.jsonl", "errors-2024.jsonl", "errors-20240102T150405.jsonl", "errors.jsonl.bak"}
+	for _, name := range foreign {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := openRotatingFile(path, 10, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		if _, err := r.Write(record(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Four rotations, of which the two newest backups are kept.
+	var kept []string
+	for _, name := range backups(t, path) {
+		if !slices.Contains(foreign, filepath.Base(name)) {
+			kept = append(kept, readFile(t, name))
+		}
+	}
+	if want := []string{string(record(2)), string(record(3))}; !slices.Equal(kept, want) {
+		t.Fatalf("backups hold %q, want %q", kept, want)
+	}
+	for _, name := range foreign {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("foreign file %s: %v", name, err)
+		}
+	}
+}
+
+func TestErrorLogCloseFlushesQueue(t *testing.T) {
+	quietLog(t)
+	path := filepath.Join(t.TempDir(), "errors.jsonl")
+	l, err := newErrorLog(path, 1<<10, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 200
+	for i := range n {
+		l.Log(fmt.Errorf("failure %d", i))
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Logging after Close is dropped, not a panic.
+	l.Log(errors.New("too late"))
+	if err := l.Close(); err != nil {
+		t.Fatalf("second Close = %v", err)
+	}
+
+	// With 1 KiB files the records are spread over several files; read
+	// them back oldest first.
+	var msgs []string
+	for _, name := range append(backups(t, path), path) {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			var rec struct {
+				Msg string `json:"msg"`
+			}
+			if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+				t.Fatalf("%s: %v in line %q", name, err, sc.Text())
+			}
+			msgs = append(msgs, rec.Msg)
+		}
+		f.Close()
+		if err := sc.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(msgs) != n {
+		t.Fatalf("%d errors on disk after Close, want %d", len(msgs), n)
+	}
+	for i, msg := range msgs {
+		if want := fmt.Sprintf("failure %d", i); msg != want {
+			t.Fatalf("line %d = %q, want %q", i, msg, want)
+		}
+	}
+}
@@ -3,6 +3,8 @@ module github.com/VarthanV/go-concurrency-exercises/pipelines
 go 1.22.6
 
 require (
+	github.com/VarthanV/go-concurrency-exercises/clock v0.0.0
+	github.com/VarthanV/go-concurrency-exercises/ratelimit v0.0.0
 	gorm.io/driver/sqlite v1.5.6
 	gorm.io/gorm v1.25.12
 )
@@ -13,3 +15,8 @@ require (
 	github.com/mattn/go-sqlite3 v1.14.22 // indirect
 	golang.org/x/text v0.14.0 // indirect
 )
+
+replace (
+	github.com/VarthanV/go-concurrency-exercises/clock => ../clock
+	github.com/VarthanV/go-concurrency-exercises/ratelimit => ../ratelimit
+)
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// hostLimiter caps how many requests may be in flight to the same host,
+// so that a fetch stage with many workers does not hammer a single site.
+type hostLimiter struct {
+	mu    sync.Mutex
+	limit int
+	hosts map[string]chan struct{}
+}
+
+func newHostLimiter(limit int) *hostLimiter {
+	return &hostLimiter{
+		limit: limit,
+		hosts: make(map[string]chan struct{}),
+	}
+}
+
+func (h *hostLimiter) semaphore(host string) chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sem, ok := h.hosts[host]
+	if !ok {
+		sem = make(chan struct{}, h.limit)
+		h.hosts[host] = sem
+	}
+	return sem
+}
+
+// acquire blocks until a slot for host is free and returns the function
+// that gives it back.
+func (h *hostLimiter) acquire(ctx context.Context, host string) (func(), error) {
+	sem := h.semaphore(host)
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	}
+}
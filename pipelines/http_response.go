@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/pipelines/pipeline"
+)
+
+// HTTPStatusError is returned when a response has a status the
+// responsePolicy does not accept.
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	// RetryAfter is the server's Retry-After hint, if it sent one.
+	RetryAfter time.Duration
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("GET %s: unexpected status %s", e.URL, e.Status)
+}
+
+// DecodeError is returned when a response arrived but its body is not
+// what we expected: the wrong content type, too large, or not valid JSON
+// for the target.
+type DecodeError struct {
+	URL         string
+	ContentType string
+	Err         error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("GET %s: decode %q body: %v", e.URL, e.ContentType, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// TransportError is returned when no complete response could be read,
+// because the connection failed or the attempt timed out.
+type TransportError struct {
+	URL string
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("GET %s: %v", e.URL, e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	errBodyTooLarge = errors.New("response body too large")
+	// errRequestTimeout is the cancellation cause of an HTTP attempt that
+	// took longer than requestTimeout.
+	errRequestTimeout = fmt.Errorf("request timed out after %v: %w", requestTimeout, context.DeadlineExceeded)
+)
+
+// responsePolicy decides which responses getJSON accepts.
+type responsePolicy struct {
+	// statuses are the accepted status codes.
+	statuses []int
+	// contentTypes are the accepted media types, without parameters.
+	contentTypes []string
+	maxBodySize  int64
+}
+
+var defaultResponsePolicy = responsePolicy{
+	statuses:     []int{http.StatusOK},
+	contentTypes: []string{"application/json"},
+	maxBodySize:  1 << 20,
+}
+
+// errorKind names the kind of HTTP failure behind err, or returns "" if
+// there is none.
+func errorKind(err error) string {
+	var (
+		se *HTTPStatusError
+		de *DecodeError
+		te *TransportError
+	)
+	switch {
+	case errors.As(err, &se):
+		return "status"
+	case errors.As(err, &de):
+		return "decode"
+	case errors.As(err, &te):
+		return "transport"
+	}
+	return ""
+}
+
+// getJSON makes a single GET attempt at url with client, sending
+// userAgent and bounded by requestTimeout, checks the response against p
+// and decodes its body into v. The body is always closed. Transport
+// failures and 429/5xx statuses are wrapped in *retryableError unless ctx
+// itself is done.
+func getJSON(ctx context.Context, client *http.Client, userAgent, url string, p responsePolicy, v any) error {
+	reqCtx, cancel := context.WithTimeoutCause(ctx, requestTimeout, errRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+
+	// transportErr only retries our own per-attempt timeout; if the caller
+	// gave up, it says why.
+	transportErr := func(err error) error {
+		if ctx.Err() != nil {
+			return &TransportError{URL: url, Err: pipeline.WithCause(ctx, err)}
+		}
+		return &retryableError{err: &TransportError{URL: url, Err: pipeline.WithCause(reqCtx, err)}}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return transportErr(err)
+	}
+	defer func() {
+		// Drain a little so the connection can be reused, but never read
+		// a large unwanted body just for that.
+		io.CopyN(io.Discard, resp.Body, 4<<10)
+		resp.Body.Close()
+	}()
+
+	if !slices.Contains(p.statuses, resp.StatusCode) {
+		err := &HTTPStatusError{
+			URL:        url,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			RetryAfter: parseRetryAfter(resp.Header),
+		}
+		if isRetryableStatus(resp.StatusCode) {
+			return &retryableError{err: err, retryAfter: err.RetryAfter}
+		}
+		return err
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if len(p.contentTypes) > 0 {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || !slices.Contains(p.contentTypes, mediaType) {
+			return &DecodeError{URL: url, ContentType: contentType,
+				Err: fmt.Errorf("unexpected content type, want one of %v", p.contentTypes)}
+		}
+	}
+
+	// Read one byte past the limit to tell a body of exactly maxBodySize
+	// from a larger one.
+	body, err := io.ReadAll(io.LimitReader(resp.Body, p.maxBodySize+1))
+	if err != nil {
+		return transportErr(err)
+	}
+	if int64(len(body)) > p.maxBodySize {
+		return &DecodeError{URL: url, ContentType: contentType,
+			Err: fmt.Errorf("%w: more than %d bytes", errBodyTooLarge, p.maxBodySize)}
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return &DecodeError{URL: url, ContentType: contentType, Err: err}
+	}
+	if val, ok := v.(validator); ok {
+		if err := val.validate(); err != nil {
+			return &DecodeError{URL: url, ContentType: contentType, Err: err}
+		}
+	}
+	return nil
+}
+
+// validator is implemented by decode targets that can tell a well-formed
+// but useless body, like null or {}, from a real one.
+type validator interface {
+	validate() error
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testUserAgent = "scraper/1.0 (+https://example.com)"
+
+// respond serves every request with status, contentType and body. An
+// empty contentType sends no Content-Type at all.
+func respond(t *testing.T, status int, header http.Header, contentType, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		// A nil value keeps net/http from sniffing a Content-Type.
+		w.Header()["Content-Type"] = nil
+		if contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGetJSON(t *testing.T) {
+	var gotUA atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUA.Store(r.Header.Get("User-Agent"))
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, `{"userId": 1, "id": 2, "title": "a", "completed": true}`)
+	}))
+	defer srv.Close()
+
+	var todo Todo
+	if err := getJSON(context.Background(), http.DefaultClient, testUserAgent, srv.URL, defaultResponsePolicy, &todo); err != nil {
+		t.Fatal(err)
+	}
+	if todo != (Todo{UserID: 1, ID: 2, Title: "a", Completed: true}) {
+		t.Fatalf("decoded %+v", todo)
+	}
+	if ua := gotUA.Load(); ua != testUserAgent {
+		t.Fatalf("server saw User-Agent %q, want %q", ua, testUserAgent)
+	}
+}
+
+func TestGetJSONStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		retryable  bool
+		wantAfter  time.Duration
+	}{
+		{name: "not found", status: http.StatusNotFound},
+		{name: "too many requests", status: http.StatusTooManyRequests, retryable: true},
+		{name: "too many requests with Retry-After", status: http.StatusTooManyRequests, retryAfter: "7", retryable: true, wantAfter: 7 * time.Second},
+		{name: "unavailable", status: http.StatusServiceUnavailable, retryable: true},
+		{name: "unavailable with Retry-After", status: http.StatusServiceUnavailable, retryAfter: "2", retryable: true, wantAfter: 2 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.retryAfter != "" {
+				header.Set("Retry-After", tt.retryAfter)
+			}
+			srv := respond(t, tt.status, header, "application/json", `{"error": "nope"}`)
+
+			var todo Todo
+			err := getJSON(context.Background(), http.DefaultClient, testUserAgent, srv.URL, defaultResponsePolicy, &todo)
+
+			var se *HTTPStatusError
+			if !errors.As(err, &se) {
+				t.Fatalf("getJSON = %v, want an *HTTPStatusError", err)
+			}
+			if se.StatusCode != tt.status || se.URL != srv.URL || se.RetryAfter != tt.wantAfter {
+				t.Fatalf("HTTPStatusError = %+v, want status %d and RetryAfter %v", se, tt.status, tt.wantAfter)
+			}
+			var re *retryableError
+			if errors.As(err, &re) != tt.retryable {
+				t.Fatalf("getJSON = %v, retryable = %v, want %v", err, !tt.retryable, tt.retryable)
+			}
+			if tt.retryable && re.retryAfter != tt.wantAfter {
+				t.Fatalf("retryAfter = %v, want %v", re.retryAfter, tt.wantAfter)
+			}
+			if kind := errorKind(err); kind != "status" {
+				t.Fatalf("errorKind = %q, want status", kind)
+			}
+		})
+	}
+}
+
+func TestGetJSONDecodeErrors(t *testing.T) {
+	small := responsePolicy{
+		statuses:     []int{http.StatusOK},
+		contentTypes: []string{"application/json"},
+		maxBodySize:  64,
+	}
+	// exactly is a valid todo padded with spaces to exactly maxBodySize.
+	exactly := `{"id": 1}` + strings.Repeat(" ", 64-len(`{"id": 1}`))
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		// check inspects the error; nil means getJSON must succeed.
+		check func(error) bool
+	}{
+		{name: "wrong media type", contentType: "text/html", body: `{"id": 1}`,
+			check: func(err error) bool { return strings.Contains(err.Error(), "unexpected content type") }},
+		{name: "no media type", contentType: "", body: `{"id": 1}`,
+			check: func(err error) bool { return strings.Contains(err.Error(), "unexpected content type") }},
+		{name: "body of exactly maxBodySize", contentType: "application/json", body: exactly},
+		{name: "body of maxBodySize+1", contentType: "application/json", body: exactly + " ",
+			check: func(err error) bool { return errors.Is(err, errBodyTooLarge) }},
+		{name: "invalid JSON", contentType: "application/json", body: `{"id": 1`,
+			check: func(err error) bool {
+				var se *json.SyntaxError
+				return errors.As(err, &se)
+			}},
+		{name: "wrong JSON type", contentType: "application/json", body: `{"id": "one"}`,
+			check: func(err error) bool {
+				var te *json.UnmarshalTypeError
+				return errors.As(err, &te)
+			}},
+		{name: "empty object", contentType: "application/json", body: `{}`,
+			check: func(err error) bool { return strings.Contains(err.Error(), "todo without an id") }},
+		{name: "null", contentType: "application/json", body: `null`,
+			check: func(err error) bool { return strings.Contains(err.Error(), "todo without an id") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := respond(t, http.StatusOK, nil, tt.contentType, tt.body)
+
+			var todo Todo
+			err := getJSON(context.Background(), http.DefaultClient, testUserAgent, srv.URL, small, &todo)
+			if tt.check == nil {
+				if err != nil {
+					t.Fatalf("getJSON = %v", err)
+				}
+				return
+			}
+
+			var de *DecodeError
+			if !errors.As(err, &de) || de.URL != srv.URL || de.ContentType != tt.contentType {
+				t.Fatalf("getJSON = %v, want a *DecodeError for %s with content type %q", err, srv.URL, tt.contentType)
+			}
+			if !tt.check(err) {
+				t.Fatalf("getJSON = %v, not the expected decode failure", err)
+			}
+			var re *retryableError
+			if errors.As(err, &re) {
+				t.Fatalf("getJSON = %v, want a bad body not to be retried", err)
+			}
+			if kind := errorKind(err); kind != "decode" {
+				t.Fatalf("errorKind = %q, want decode", kind)
+			}
+		})
+	}
+}
+
+func TestGetJSONTransportError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	var todo Todo
+	err := getJSON(context.Background(), http.DefaultClient, testUserAgent, url, defaultResponsePolicy, &todo)
+	var te *TransportError
+	if !errors.As(err, &te) || te.URL != url {
+		t.Fatalf("getJSON = %v, want a *TransportError for %s", err, url)
+	}
+	var re *retryableError
+	if !errors.As(err, &re) {
+		t.Fatalf("getJSON = %v, want a failed connection to be retryable", err)
+	}
+	if kind := errorKind(err); kind != "transport" {
+		t.Fatalf("errorKind = %q, want transport", kind)
+	}
+
+	// Once the caller gives up there is no point retrying, and the error
+	// says why.
+	cause := errors.New("shutting down")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(cause)
+	err = getJSON(ctx, http.DefaultClient, testUserAgent, url, defaultResponsePolicy, &todo)
+	if !errors.As(err, &te) || errors.As(err, &re) || !errors.Is(err, cause) {
+		t.Fatalf("getJSON = %v after cancel, want a non-retryable *TransportError carrying the cause", err)
+	}
+}
+
+// TestGetJSONErrorsUnwrap checks that the typed errors can still be found
+// once retry has wrapped them.
+func TestGetJSONErrorsUnwrap(t *testing.T) {
+	statusSrv := respond(t, http.StatusServiceUnavailable, nil, "", "")
+	decodeSrv := respond(t, http.StatusOK, nil, "application/json", `[`)
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	fetch := func(url string) error {
+		_, _, err := retry(context.Background(), retryPolicy{maxAttempts: 1}, func(ctx context.Context) (*Todo, error) {
+			var todo Todo
+			return &todo, getJSON(ctx, http.DefaultClient, testUserAgent, url, defaultResponsePolicy, &todo)
+		})
+		return err
+	}
+
+	var se *HTTPStatusError
+	if err := fetch(statusSrv.URL); !errors.As(err, &se) || se.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("errors.As(%v, *HTTPStatusError) failed", err)
+	}
+	var de *DecodeError
+	if err := fetch(decodeSrv.URL); !errors.As(err, &de) {
+		t.Errorf("errors.As(%v, *DecodeError) failed", err)
+	}
+	var te *TransportError
+	if err := fetch(closed.URL); !errors.As(err, &te) {
+		t.Errorf("errors.As(%v, *TransportError) failed", err)
+	}
+}
+
+// trackedBody records how much of it was read and whether it was closed.
+type trackedBody struct {
+	r      io.Reader
+	read   atomic.Int64
+	closed atomic.Bool
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.read.Add(int64(n))
+	return n, err
+}
+
+func (b *trackedBody) Close() error {
+	b.closed.Store(true)
+	return nil
+}
+
+type bodyTransport struct {
+	status int
+	body   *trackedBody
+}
+
+func (t bodyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: t.status,
+		Status:     http.StatusText(t.status),
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       t.body,
+		Request:    req,
+	}, nil
+}
+
+func TestGetJSONClosesAndDrainsBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		size     int
+		wantRead int64
+	}{
+		// A short error body is read to the end so the connection can be
+		// reused.
+		{name: "short error body", status: http.StatusInternalServerError, size: 1 << 10, wantRead: 1 << 10},
+		// A long one is drained only a little before giving up on it.
+		{name: "long error body", status: http.StatusInternalServerError, size: 1 << 20, wantRead: 4 << 10},
+		{name: "body too large", status: http.StatusOK, size: 2 << 20, wantRead: defaultResponsePolicy.maxBodySize + 1 + 4<<10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &trackedBody{r: strings.NewReader(strings.Repeat(" ", tt.size))}
+			client := &http.Client{Transport: bodyTransport{status: tt.status, body: body}}
+
+			var todo Todo
+			if err := getJSON(context.Background(), client, testUserAgent, "http://example.com/todos/1", defaultResponsePolicy, &todo); err == nil {
+				t.Fatal("getJSON succeeded")
+			}
+			if !body.closed.Load() {
+				t.Fatal("body not closed")
+			}
+			if n := body.read.Load(); n != tt.wantRead {
+				t.Fatalf("read %d bytes of the body, want %d", n, tt.wantRead)
+			}
+		})
+	}
+
+	// A body that decodes is closed too.
+	body := &trackedBody{r: strings.NewReader(`{"id": 1}`)}
+	client := &http.Client{Transport: bodyTransport{status: http.StatusOK, body: body}}
+	var todo Todo
+	if err := getJSON(context.Background(), client, testUserAgent, "http://example.com/todos/1", defaultResponsePolicy, &todo); err != nil {
+		t.Fatal(err)
+	}
+	if !body.closed.Load() {
+		t.Fatal("body not closed after a successful decode")
+	}
+}
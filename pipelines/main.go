@@ -1,6 +1,10 @@
 package main
 
-import "fmt"
+import (
+	"flag"
+	"fmt"
+	"log"
+)
 
 func basicPipeline() {
 	mutliply := func(values []int, multiplier int) []int {
@@ -28,6 +32,15 @@ func basicPipeline() {
 }
 
 func main() {
+	flag.Parse()
+
+	if flag.Arg(0) == "deadletters" {
+		if err := deadLetterCommand(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	basicPipeline()
 	WebScrapperPipelineDriver()
 }
@@ -0,0 +1,262 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the latency
+// histograms.
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// histogram counts observations into latencyBuckets, Prometheus style.
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+	max    float64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(latencyBuckets))}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	v := d.Seconds()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range latencyBuckets {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+	h.max = math.Max(h.max, v)
+}
+
+// snapshot returns cumulative bucket counts along with the totals.
+func (h *histogram) snapshot() (cumulative []uint64, count uint64, sum, max float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cumulative = make([]uint64, len(h.counts))
+	var total uint64
+	for i, c := range h.counts {
+		total += c
+		cumulative[i] = total
+	}
+	return cumulative, h.count, h.sum, h.max
+}
+
+// quantile estimates the q-th quantile as the upper bound of the bucket it
+// falls in, or the largest observation if it is beyond the last bucket.
+func (h *histogram) quantile(q float64) float64 {
+	cumulative, count, _, max := h.snapshot()
+	if count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(count)))
+	for i, c := range cumulative {
+		if c >= rank {
+			return math.Min(latencyBuckets[i], max)
+		}
+	}
+	return max
+}
+
+// stageMetrics are the metrics of one stage. A nil *stageMetrics ignores
+// every call, so stages do not have to check whether metrics are on.
+type stageMetrics struct {
+	name    string
+	in      atomic.Int64
+	out     atomic.Int64
+	errored atomic.Int64
+	latency *histogram
+	// queue reports how many items wait in the stage's output channel.
+	queue atomic.Pointer[func() int]
+	// handingOff counts results blocked on a send to the next stage, which
+	// is where they wait when the output channel is unbuffered.
+	handingOff atomic.Int64
+}
+
+func (s *stageMetrics) received() {
+	if s != nil {
+		s.in.Add(1)
+	}
+}
+
+func (s *stageMetrics) emitted() {
+	if s != nil {
+		s.out.Add(1)
+	}
+}
+
+func (s *stageMetrics) failed() {
+	if s != nil {
+		s.errored.Add(1)
+	}
+}
+
+// timed records the latency of a stage call started at start.
+func (s *stageMetrics) timed(start time.Time) {
+	if s != nil {
+		s.latency.observe(time.Since(start))
+	}
+}
+
+func (s *stageMetrics) watchQueue(depth func() int) {
+	if s != nil {
+		s.queue.Store(&depth)
+	}
+}
+
+// sending brackets a send to the next stage; call done once it returns.
+func (s *stageMetrics) sending() (done func()) {
+	if s == nil {
+		return func() {}
+	}
+	s.handingOff.Add(1)
+	return func() { s.handingOff.Add(-1) }
+}
+
+// queueDepth is how many finished items the next stage has not taken yet:
+// those in the output channel's buffer and those still being handed off.
+func (s *stageMetrics) queueDepth() int {
+	depth := int(s.handingOff.Load())
+	if buffered := s.queue.Load(); buffered != nil {
+		depth += (*buffered)()
+	}
+	return depth
+}
+
+// Metrics collects stageMetrics by stage name. Running a pipeline
+// more than once with the same Metrics adds up the counts.
+type Metrics struct {
+	mu     sync.Mutex
+	stages map[string]*stageMetrics
+	order  []*stageMetrics
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{stages: make(map[string]*stageMetrics)}
+}
+
+// stage returns the metrics for name, creating them on first use. It
+// returns nil if m is nil.
+func (m *Metrics) stage(name string) *stageMetrics {
+	if m == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.stages[name]
+	if !ok {
+		s = &stageMetrics{name: name, latency: newHistogram()}
+		m.stages[name] = s
+		m.order = append(m.order, s)
+	}
+	return s
+}
+
+// all returns the stages in the order they were added.
+func (m *Metrics) all() []*stageMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*stageMetrics(nil), m.order...)
+}
+
+// labelEscaper escapes a label value as the Prometheus text format wants
+// it, which is not how Go quotes strings.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// label formats one name="value" pair.
+func label(name, value string) string {
+	return name + `="` + labelEscaper.Replace(value) + `"`
+}
+
+// WritePrometheus writes every metric in the Prometheus text format.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	stages := m.all()
+
+	counters := []struct {
+		name, help string
+		value      func(*stageMetrics) int64
+	}{
+		{"pipeline_items_in_total", "Items a stage received.", func(s *stageMetrics) int64 { return s.in.Load() }},
+		{"pipeline_items_out_total", "Items a stage passed on or, for a sink, consumed.", func(s *stageMetrics) int64 { return s.out.Load() }},
+		{"pipeline_items_errored_total", "Items a stage failed on.", func(s *stageMetrics) int64 { return s.errored.Load() }},
+	}
+	for _, c := range counters {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+		for _, s := range stages {
+			fmt.Fprintf(w, "%s{%s} %d\n", c.name, label("stage", s.name), c.value(s))
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP pipeline_queue_depth Items a stage has finished that the next stage has not taken yet.")
+	fmt.Fprintln(w, "# TYPE pipeline_queue_depth gauge")
+	for _, s := range stages {
+		fmt.Fprintf(w, "pipeline_queue_depth{%s} %d\n", label("stage", s.name), s.queueDepth())
+	}
+
+	fmt.Fprintln(w, "# HELP pipeline_stage_latency_seconds Time a stage spent on one call.")
+	fmt.Fprintln(w, "# TYPE pipeline_stage_latency_seconds histogram")
+	for _, s := range stages {
+		stage := label("stage", s.name)
+		cumulative, count, sum, _ := s.latency.snapshot()
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(w, "pipeline_stage_latency_seconds_bucket{%s,%s} %d\n",
+				stage, label("le", strconv.FormatFloat(bound, 'g', -1, 64)), cumulative[i])
+		}
+		fmt.Fprintf(w, "pipeline_stage_latency_seconds_bucket{%s,le=\"+Inf\"} %d\n", stage, count)
+		fmt.Fprintf(w, "pipeline_stage_latency_seconds_sum{%s} %g\n", stage, sum)
+		_, err := fmt.Fprintf(w, "pipeline_stage_latency_seconds_count{%s} %d\n", stage, count)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeHTTP serves the metrics to a Prometheus scraper.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WritePrometheus(w)
+}
+
+// WriteSummary prints one line per stage with its counts and latencies.
+// P50 and P95 are estimated from the histogram buckets.
+func (m *Metrics) WriteSummary(w io.Writer) error {
+	dur := func(seconds float64) string {
+		return (time.Duration(seconds * float64(time.Second))).Round(time.Microsecond).String()
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STAGE\tIN\tOUT\tERRORED\tCALLS\tMEAN\tP50\tP95\tMAX")
+	for _, s := range m.all() {
+		_, count, sum, max := s.latency.snapshot()
+		mean := 0.0
+		if count > 0 {
+			mean = sum / float64(count)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t%s\n",
+			s.name, s.in.Load(), s.out.Load(), s.errored.Load(), count,
+			dur(mean), dur(s.latency.quantile(0.5)), dur(s.latency.quantile(0.95)), dur(max))
+	}
+	return tw.Flush()
+}
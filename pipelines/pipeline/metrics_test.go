@@ -0,0 +1,96 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// golden compares got with testdata/name, or rewrites the file with -update.
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("output differs from %s (rerun with -update if the change is intended):\n%s", path, got)
+	}
+}
+
+func TestWritePrometheusGolden(t *testing.T) {
+	m := NewMetrics()
+
+	fetch := m.stage("fetch")
+	for range 3 {
+		fetch.received()
+	}
+	fetch.emitted()
+	fetch.emitted()
+	fetch.failed()
+	for _, d := range []time.Duration{2 * time.Millisecond, 40 * time.Millisecond, 45 * time.Second} {
+		fetch.latency.observe(d)
+	}
+	// Two items in the buffer and one more waiting to be taken.
+	fetch.watchQueue(func() int { return 2 })
+	done := fetch.sending()
+	defer done()
+
+	// Label values escape backslash, double quote and newline, and nothing
+	// else: Go's %q would turn the é into a \u escape too.
+	odd := m.stage("store \"todos\" in C:\\db\ncafé")
+	odd.received()
+	odd.emitted()
+	odd.latency.observe(300 * time.Millisecond)
+
+	var buf bytes.Buffer
+	if err := m.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "prometheus.golden", buf.Bytes())
+}
+
+// TestQueueDepthUnbuffered checks that an item a stage is stuck handing
+// to the next one shows up in the queue depth even though the channel
+// between them has no buffer.
+func TestQueueDepthUnbuffered(t *testing.T) {
+	checkNoLeaks(t)
+	m := NewMetrics()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	block := func(ctx context.Context, v int) error {
+		<-ctx.Done()
+		return nil
+	}
+	errs := Through(From("count", count(-1)), "double", double).To("block", block).WithMetrics(m).Run(ctx)
+
+	// The sink holds one item, double is stuck handing over the next and
+	// count the one after that.
+	deadline := time.Now().Add(5 * time.Second)
+	for m.stage("count").queueDepth() != 1 || m.stage("double").queueDepth() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue depths count=%d double=%d, want 1 each",
+				m.stage("count").queueDepth(), m.stage("double").queueDepth())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	drain(t, errs)
+	if depth := m.stage("double").queueDepth(); depth != 0 {
+		t.Fatalf("queue depth %d after the run, want 0", depth)
+	}
+}
@@ -0,0 +1,540 @@
+// Package pipeline runs generic, concurrent pipelines: a Source feeds a
+// chain of Stages, each running on its own goroutines, and a Sink consumes
+// what comes out. The package owns the channels, their closing and
+// cancellation, so stages are plain functions of one item or one batch.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Source produces the items that enter a pipeline. emit hands one item to
+// the next stage and returns false once the pipeline is cancelled, at
+// which point the source should return.
+type Source[T any] func(ctx context.Context, emit func(T) bool) error
+
+// Stage turns one item into another. Stages are plain functions: reading
+// from and writing to channels, closing them and watching for cancellation
+// is done once by the pipeline.
+type Stage[In, Out any] func(ctx context.Context, in In) (Out, error)
+
+// Sink consumes the items that leave a pipeline.
+type Sink[T any] func(ctx context.Context, in T) error
+
+// StageError is reported when a stage fails on an item. It keeps the item
+// around so that the failure can be logged or retried later.
+type StageError[T any] struct {
+	Stage string
+	Item  T
+	Err   error
+}
+
+func (e *StageError[T]) Error() string {
+	return fmt.Sprintf("stage %s failed on %v: %v", e.Stage, e.Item, e.Err)
+}
+
+func (e *StageError[T]) Unwrap() error {
+	return e.Err
+}
+
+// StageName and Input let code that does not know T inspect the error.
+func (e *StageError[T]) StageName() string {
+	return e.Stage
+}
+
+func (e *StageError[T]) Input() any {
+	return e.Item
+}
+
+// stageTimeoutError is the cancellation cause of a stage call that ran
+// past the timeout set with WithTimeout.
+type stageTimeoutError struct {
+	stage   string
+	timeout time.Duration
+}
+
+func (e *stageTimeoutError) Error() string {
+	return fmt.Sprintf("stage %s timed out after %v", e.stage, e.timeout)
+}
+
+// Unwrap keeps errors.Is(err, context.DeadlineExceeded) working.
+func (e *stageTimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// WithCause adds the reason ctx was cancelled to err, so that a timeout can
+// be told apart from an abort. Stage functions can use it for errors they
+// build themselves. err is returned unchanged if ctx is still
+// live or err already carries the cause.
+func WithCause(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	cause := context.Cause(ctx)
+	if errors.Is(err, cause) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", err, cause)
+}
+
+// runner tracks the goroutines and the error stream of one pipeline run.
+type runner struct {
+	wg      sync.WaitGroup
+	errs    chan error
+	metrics *Metrics
+}
+
+func (r *runner) spawn(fn func()) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		fn()
+	}()
+}
+
+// report sends err on the error stream. It does not give up once ctx is
+// done: Run's caller drains the stream until it closes, and the failures
+// of items that were in flight at cancellation are the ones most worth
+// logging.
+func (r *runner) report(err error) {
+	r.errs <- err
+}
+
+// send delivers v on out unless ctx is done first.
+func send[T any](ctx context.Context, out chan<- T, v T) bool {
+	select {
+	case out <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// handOff is send for a stage's output: while v waits for the next stage
+// to take it, it counts towards m's queue depth.
+func handOff[T any](ctx context.Context, m *stageMetrics, out chan<- T, v T) bool {
+	defer m.sending()()
+	return send(ctx, out, v)
+}
+
+// Flow is a pipeline under construction whose last stage emits T.
+type Flow[T any] struct {
+	start func(ctx context.Context, r *runner) <-chan T
+}
+
+// From starts a flow with src.
+func From[T any](name string, src Source[T]) *Flow[T] {
+	return &Flow[T]{start: func(ctx context.Context, r *runner) <-chan T {
+		out := make(chan T)
+		m := r.metrics.stage(name)
+		m.watchQueue(func() int { return len(out) })
+
+		r.spawn(func() {
+			defer close(out)
+
+			emit := func(v T) bool {
+				if !handOff(ctx, m, out, v) {
+					return false
+				}
+				m.emitted()
+				return true
+			}
+			start := time.Now()
+			err := src(ctx, emit)
+			m.timed(start)
+			if err != nil {
+				m.failed()
+				r.report(&StageError[any]{Stage: name, Err: err})
+			}
+		})
+
+		return out
+	}}
+}
+
+// stageConfig holds the options a stage was added with.
+type stageConfig struct {
+	workers int
+	ordered bool
+	timeout time.Duration
+	buffer  int
+}
+
+func newStageConfig(opts []StageOption) stageConfig {
+	cfg := stageConfig{workers: 1}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// stageContext returns the context a single stage call runs with.
+func (c stageConfig) stageContext(ctx context.Context, name string) (context.Context, context.CancelFunc) {
+	if c.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeoutCause(ctx, c.timeout, &stageTimeoutError{stage: name, timeout: c.timeout})
+}
+
+// StageOption tunes how Through runs a stage.
+type StageOption func(*stageConfig)
+
+// WithWorkers runs the stage on n goroutines at once.
+func WithWorkers(n int) StageOption {
+	return func(c *stageConfig) {
+		c.workers = max(1, n)
+	}
+}
+
+// Ordered makes a stage with several workers emit results in input order.
+// Results that finish early wait in a reorder buffer of at most two per
+// worker; without Ordered they are emitted as soon as they are ready.
+func Ordered() StageOption {
+	return func(c *stageConfig) {
+		c.ordered = true
+	}
+}
+
+// WithTimeout limits every call of the stage to d. A call that runs out of
+// time sees its context cancelled with a *stageTimeoutError as the cause.
+func WithTimeout(d time.Duration) StageOption {
+	return func(c *stageConfig) {
+		c.timeout = d
+	}
+}
+
+// WithBuffer gives the stage's output channel room for n items, so that it
+// can run ahead of a slower next stage.
+func WithBuffer(n int) StageOption {
+	return func(c *stageConfig) {
+		c.buffer = max(0, n)
+	}
+}
+
+// Through appends stage to f. Items the stage fails on are reported as a
+// *StageError[In] and not passed on.
+func Through[In, Out any](f *Flow[In], name string, stage Stage[In, Out], opts ...StageOption) *Flow[Out] {
+	cfg := newStageConfig(opts)
+
+	return &Flow[Out]{start: func(ctx context.Context, r *runner) <-chan Out {
+		in := f.start(ctx, r)
+		out := make(chan Out, cfg.buffer)
+		m := r.metrics.stage(name)
+		m.watchQueue(func() int { return len(out) })
+
+		apply := func(v In) (Out, bool) {
+			m.received()
+			stageCtx, cancel := cfg.stageContext(ctx, name)
+			defer cancel()
+
+			start := time.Now()
+			res, err := stage(stageCtx, v)
+			m.timed(start)
+			if err != nil {
+				m.failed()
+				err = WithCause(stageCtx, err)
+				r.report(&StageError[In]{Stage: name, Item: v, Err: err})
+				return res, false
+			}
+			m.emitted()
+			return res, true
+		}
+
+		switch {
+		case cfg.workers == 1:
+			r.spawn(func() {
+				defer close(out)
+				runWorker(ctx, m, in, out, apply)
+			})
+		case !cfg.ordered:
+			var wg sync.WaitGroup
+			wg.Add(cfg.workers)
+			for i := 0; i < cfg.workers; i++ {
+				r.spawn(func() {
+					defer wg.Done()
+					runWorker(ctx, m, in, out, apply)
+				})
+			}
+			r.spawn(func() {
+				wg.Wait()
+				close(out)
+			})
+		default:
+			runOrdered(ctx, r, m, cfg.workers, in, out, apply)
+		}
+
+		return out
+	}}
+}
+
+// runWorker applies fn to items from in until in is closed or ctx is done.
+func runWorker[In, Out any](ctx context.Context, m *stageMetrics, in <-chan In, out chan<- Out, fn func(In) (Out, bool)) {
+	for {
+		select {
+		case v, ok := <-in:
+			if !ok {
+				return
+			}
+			res, ok := fn(v)
+			if !ok {
+				continue
+			}
+			if !handOff(ctx, m, out, res) {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+type sequenced[T any] struct {
+	seq   int
+	value T
+	ok    bool
+}
+
+// runOrdered fans items out to workers tagged with a sequence number and
+// puts the results back in that order before emitting them.
+func runOrdered[In, Out any](ctx context.Context, r *runner, m *stageMetrics, workers int, in <-chan In, out chan<- Out, fn func(In) (Out, bool)) {
+	var wg sync.WaitGroup
+
+	jobs := make(chan sequenced[In])
+	results := make(chan sequenced[Out])
+	// slots bounds how far the dispatcher may run ahead of the oldest
+	// unfinished item, and with it the size of the reorder buffer.
+	slots := make(chan struct{}, 2*workers)
+
+	r.spawn(func() {
+		defer close(jobs)
+		for seq := 0; ; seq++ {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				if !send(ctx, jobs, sequenced[In]{seq: seq, value: v}) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		r.spawn(func() {
+			defer wg.Done()
+			for job := range jobs {
+				res, ok := fn(job.value)
+				if !send(ctx, results, sequenced[Out]{seq: job.seq, value: res, ok: ok}) {
+					return
+				}
+			}
+		})
+	}
+	r.spawn(func() {
+		wg.Wait()
+		close(results)
+	})
+
+	r.spawn(func() {
+		defer close(out)
+
+		pending := make(map[int]sequenced[Out])
+		next := 0
+		for res := range results {
+			pending[res.seq] = res
+			for {
+				head, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				<-slots
+				if head.ok && !handOff(ctx, m, out, head.value) {
+					return
+				}
+			}
+		}
+	})
+}
+
+// BatchStage processes a batch of items at once and returns one result and
+// one error per item, in the same order as batch.
+type BatchStage[In, Out any] func(ctx context.Context, batch []In) ([]Out, []error)
+
+// ThroughBatch appends a stage that works on batches. Items are collected
+// until size of them are waiting or the oldest has waited maxLatency, and
+// whatever is left is flushed when the input closes. Results are passed on
+// one by one and every failed item is reported as its own *StageError[In].
+//
+// If ctx is cancelled with items still waiting, they are flushed without
+// the cancellation so that work already accepted is not lost, but their
+// results are dropped.
+//
+// Of the options only WithTimeout and WithBuffer apply; the timeout limits
+// each batch.
+func ThroughBatch[In, Out any](f *Flow[In], name string, size int, maxLatency time.Duration, stage BatchStage[In, Out], opts ...StageOption) *Flow[Out] {
+	size = max(1, size)
+	cfg := newStageConfig(opts)
+
+	return &Flow[Out]{start: func(ctx context.Context, r *runner) <-chan Out {
+		in := f.start(ctx, r)
+		out := make(chan Out, cfg.buffer)
+		m := r.metrics.stage(name)
+		m.watchQueue(func() int { return len(out) })
+
+		r.spawn(func() {
+			defer close(out)
+
+			var (
+				batch []In
+				timer = time.NewTimer(maxLatency)
+			)
+			stopTimer(timer)
+			defer timer.Stop()
+
+			// flush runs the stage with stageCtx but reports and emits with
+			// ctx, so a flush after cancellation does the work and drops
+			// the results instead of blocking.
+			flush := func(stageCtx context.Context) bool {
+				if len(batch) == 0 {
+					return true
+				}
+				stopTimer(timer)
+				items := batch
+				batch = nil
+
+				stageCtx, cancel := cfg.stageContext(stageCtx, name)
+				defer cancel()
+
+				start := time.Now()
+				results, errs := stage(stageCtx, items)
+				m.timed(start)
+				for i, v := range items {
+					if errs[i] != nil {
+						m.failed()
+						err := WithCause(stageCtx, errs[i])
+						r.report(&StageError[In]{Stage: name, Item: v, Err: err})
+						continue
+					}
+					if !handOff(ctx, m, out, results[i]) {
+						return false
+					}
+					m.emitted()
+				}
+				return true
+			}
+
+			for {
+				select {
+				case v, ok := <-in:
+					if !ok {
+						flush(ctx)
+						return
+					}
+					m.received()
+					if len(batch) == 0 {
+						timer.Reset(maxLatency)
+					}
+					batch = append(batch, v)
+					if len(batch) >= size && !flush(ctx) {
+						return
+					}
+				case <-timer.C:
+					if !flush(ctx) {
+						return
+					}
+				case <-ctx.Done():
+					flush(context.WithoutCancel(ctx))
+					return
+				}
+			}
+		})
+
+		return out
+	}}
+}
+
+// stopTimer stops t and drains a tick that may already be pending, so a
+// later Reset starts from a clean channel.
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+}
+
+// Pipeline is a complete flow ending in a sink, ready to run.
+type Pipeline struct {
+	start   func(ctx context.Context, r *runner)
+	metrics *Metrics
+}
+
+// WithMetrics makes every stage record its counts, latencies and queue
+// depth in m while the pipeline runs.
+func (p *Pipeline) WithMetrics(m *Metrics) *Pipeline {
+	p.metrics = m
+	return p
+}
+
+// To terminates f with sink.
+func (f *Flow[T]) To(name string, sink Sink[T]) *Pipeline {
+	return &Pipeline{start: func(ctx context.Context, r *runner) {
+		in := f.start(ctx, r)
+		m := r.metrics.stage(name)
+
+		r.spawn(func() {
+			for {
+				select {
+				case v, ok := <-in:
+					if !ok {
+						return
+					}
+					m.received()
+					start := time.Now()
+					err := sink(ctx, v)
+					m.timed(start)
+					if err != nil {
+						m.failed()
+						r.report(&StageError[T]{Stage: name, Item: v, Err: err})
+						continue
+					}
+					m.emitted()
+				case <-ctx.Done():
+					return
+				}
+			}
+		})
+	}}
+}
+
+// Run starts every stage and returns the stream of errors they report.
+// The stream is closed once all stage goroutines have exited, either
+// because the source ran dry or because ctx was cancelled. The caller must
+// keep draining it until then.
+func (p *Pipeline) Run(ctx context.Context) <-chan error {
+	r := &runner{errs: make(chan error), metrics: p.metrics}
+	p.start(ctx, r)
+
+	go func() {
+		r.wg.Wait()
+		close(r.errs)
+	}()
+
+	return r.errs
+}
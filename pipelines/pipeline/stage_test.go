@@ -0,0 +1,393 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// count emits 0, 1, ... up to n, or forever if n < 0.
+func count(n int) Source[int] {
+	return func(ctx context.Context, emit func(int) bool) error {
+		for i := 0; n < 0 || i < n; i++ {
+			if !emit(i) {
+				return nil
+			}
+		}
+		return nil
+	}
+}
+
+// collect returns a sink that appends to *got.
+func collect[T any](mu *sync.Mutex, got *[]T) Sink[T] {
+	return func(ctx context.Context, v T) error {
+		mu.Lock()
+		defer mu.Unlock()
+		*got = append(*got, v)
+		return nil
+	}
+}
+
+// drain reads errs until it is closed, failing if that takes too long.
+func drain(t *testing.T, errs <-chan error) []error {
+	t.Helper()
+	var out []error
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case err, ok := <-errs:
+			if !ok {
+				return out
+			}
+			out = append(out, err)
+		case <-timeout:
+			t.Fatal("error stream not closed; a stage goroutine did not exit")
+		}
+	}
+}
+
+// checkNoLeaks fails if goroutines started during the test are still
+// running shortly after it.
+func checkNoLeaks(t *testing.T) {
+	t.Helper()
+	before := runtime.NumGoroutine()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(time.Second)
+		for runtime.NumGoroutine() > before {
+			if time.Now().After(deadline) {
+				t.Errorf("%d goroutines running, %d before the test", runtime.NumGoroutine(), before)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
+
+var errOdd = errors.New("odd")
+
+func double(ctx context.Context, v int) (int, error) {
+	return 2 * v, nil
+}
+
+func evenOnly(ctx context.Context, v int) (int, error) {
+	if v%2 != 0 {
+		return 0, errOdd
+	}
+	return v, nil
+}
+
+// stageOptions are the ways Through can run a stage.
+var stageOptions = []struct {
+	name string
+	opts []StageOption
+}{
+	{"single", nil},
+	{"workers", []StageOption{WithWorkers(4)}},
+	{"ordered", []StageOption{WithWorkers(4), Ordered()}},
+	{"buffered", []StageOption{WithWorkers(4), WithBuffer(8)}},
+}
+
+func TestPipelineRun(t *testing.T) {
+	for _, tc := range stageOptions {
+		t.Run(tc.name, func(t *testing.T) {
+			checkNoLeaks(t)
+
+			var (
+				mu  sync.Mutex
+				got []int
+			)
+			evens := Through(From("count", count(10)), "evenOnly", evenOnly, tc.opts...)
+			doubled := Through(evens, "double", double, tc.opts...)
+			errs := drain(t, doubled.To("collect", collect(&mu, &got)).Run(context.Background()))
+
+			slices.Sort(got)
+			if want := []int{0, 4, 8, 12, 16}; !slices.Equal(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			if len(errs) != 5 {
+				t.Errorf("%d errors, want one per odd number", len(errs))
+			}
+		})
+	}
+}
+
+func TestStageErrorCarriesItem(t *testing.T) {
+	evens := Through(From("count", count(4)), "evenOnly", evenOnly)
+	errs := drain(t, evens.To("discard", func(context.Context, int) error { return nil }).Run(context.Background()))
+
+	var items []int
+	for _, err := range errs {
+		var se *StageError[int]
+		if !errors.As(err, &se) {
+			t.Fatalf("error %v is not a *StageError[int]", err)
+		}
+		if se.Stage != "evenOnly" || se.StageName() != "evenOnly" {
+			t.Errorf("Stage = %q, want evenOnly", se.Stage)
+		}
+		if se.Input() != any(se.Item) {
+			t.Errorf("Input() = %v, want the item %v", se.Input(), se.Item)
+		}
+		if !errors.Is(err, errOdd) {
+			t.Errorf("errors.Is(%v, errOdd) = false", err)
+		}
+		items = append(items, se.Item)
+	}
+	if want := []int{1, 3}; !slices.Equal(items, want) {
+		t.Fatalf("failed items %v, want %v", items, want)
+	}
+}
+
+func TestSourceAndSinkErrors(t *testing.T) {
+	errSource := errors.New("source failed")
+	errSink := errors.New("sink failed")
+
+	src := func(ctx context.Context, emit func(int) bool) error {
+		emit(7)
+		return errSource
+	}
+	sink := func(ctx context.Context, v int) error { return errSink }
+
+	errs := drain(t, From("src", Source[int](src)).To("sink", sink).Run(context.Background()))
+	if len(errs) != 2 {
+		t.Fatalf("errors %v, want one from the source and one from the sink", errs)
+	}
+	for _, err := range errs {
+		var (
+			anyErr *StageError[any]
+			intErr *StageError[int]
+		)
+		switch {
+		case errors.As(err, &anyErr):
+			if anyErr.Stage != "src" || !errors.Is(err, errSource) {
+				t.Errorf("source error %v", err)
+			}
+		case errors.As(err, &intErr):
+			if intErr.Stage != "sink" || intErr.Item != 7 || !errors.Is(err, errSink) {
+				t.Errorf("sink error %v", err)
+			}
+		default:
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+}
+
+func TestPipelineCancel(t *testing.T) {
+	for _, tc := range stageOptions {
+		t.Run(tc.name, func(t *testing.T) {
+			checkNoLeaks(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+
+			// The sink blocks until cancelled, so every stage before it
+			// backs up and is blocked on a send when ctx is cancelled.
+			started := make(chan struct{})
+			var once sync.Once
+			sink := func(ctx context.Context, v int) error {
+				once.Do(func() { close(started) })
+				<-ctx.Done()
+				return nil
+			}
+
+			flow := Through(From("count", count(-1)), "double", double, tc.opts...)
+			batched := ThroughBatch(flow, "batch", 3, time.Hour,
+				func(ctx context.Context, batch []int) ([]int, []error) {
+					return batch, make([]error, len(batch))
+				})
+			errs := batched.To("block", sink).Run(ctx)
+
+			<-started
+			cancel()
+			if got := drain(t, errs); len(got) != 0 {
+				t.Errorf("errors %v after cancel, want none", got)
+			}
+		})
+	}
+}
+
+// TestCancelReportsInFlightFailures cancels a run while every worker is
+// busy: each of their failures must still come out, carrying the cause.
+func TestCancelReportsInFlightFailures(t *testing.T) {
+	for _, tc := range stageOptions {
+		t.Run(tc.name, func(t *testing.T) {
+			checkNoLeaks(t)
+
+			const items = 4
+			workers := newStageConfig(tc.opts).workers
+			cause := errors.New("shutting down")
+			ctx, cancel := context.WithCancelCause(context.Background())
+
+			// The first item of each worker is in flight when we cancel.
+			var inFlight sync.WaitGroup
+			inFlight.Add(workers)
+			stuck := func(ctx context.Context, v int) (int, error) {
+				if v < workers {
+					inFlight.Done()
+				}
+				<-ctx.Done()
+				return 0, ctx.Err()
+			}
+
+			flow := Through(From("count", count(items)), "stuck", stuck, tc.opts...)
+			errs := flow.To("discard", func(context.Context, int) error { return nil }).Run(ctx)
+			inFlight.Wait()
+			cancel(cause)
+
+			got := drain(t, errs)
+			if len(got) < workers {
+				t.Fatalf("%d errors after cancel, want one for each of the %d busy workers", len(got), workers)
+			}
+			seen := make(map[int]bool)
+			for _, err := range got {
+				var se *StageError[int]
+				if !errors.As(err, &se) || se.Stage != "stuck" {
+					t.Fatalf("error %v is not a *StageError[int] from stuck", err)
+				}
+				if !errors.Is(err, cause) {
+					t.Fatalf("error %v does not carry the cancel cause", err)
+				}
+				if seen[se.Item] {
+					t.Fatalf("item %d reported twice", se.Item)
+				}
+				seen[se.Item] = true
+			}
+		})
+	}
+}
+
+func TestStageTimeout(t *testing.T) {
+	slow := func(ctx context.Context, v int) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+	flow := Through(From("count", count(1)), "slow", slow, WithTimeout(time.Millisecond))
+	errs := drain(t, flow.To("discard", func(context.Context, int) error { return nil }).Run(context.Background()))
+
+	if len(errs) != 1 {
+		t.Fatalf("errors %v, want one", errs)
+	}
+	var te *stageTimeoutError
+	if !errors.As(errs[0], &te) || te.stage != "slow" {
+		t.Fatalf("error %v does not carry the stage timeout", errs[0])
+	}
+	if !errors.Is(errs[0], context.DeadlineExceeded) {
+		t.Fatalf("errors.Is(%v, DeadlineExceeded) = false", errs[0])
+	}
+}
+
+func TestThroughBatch(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		batches [][]int
+		got     []int
+	)
+	record := func(ctx context.Context, batch []int) ([]int, []error) {
+		mu.Lock()
+		batches = append(batches, slices.Clone(batch))
+		mu.Unlock()
+
+		errs := make([]error, len(batch))
+		for i, v := range batch {
+			if v == 4 {
+				errs[i] = errOdd
+			}
+		}
+		return batch, errs
+	}
+
+	flow := ThroughBatch(From("count", count(7)), "batch", 3, time.Hour, record)
+	errs := drain(t, flow.To("collect", collect(&mu, &got)).Run(context.Background()))
+
+	// Full batches of 3 and the rest flushed when the input closes.
+	if len(batches) != 3 || len(batches[2]) != 1 {
+		t.Fatalf("batches %v, want sizes 3, 3, 1", batches)
+	}
+	if want := []int{0, 1, 2, 3, 5, 6}; !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	var se *StageError[int]
+	if len(errs) != 1 || !errors.As(errs[0], &se) || se.Item != 4 {
+		t.Fatalf("errors %v, want one for item 4", errs)
+	}
+}
+
+func TestThroughBatchMaxLatency(t *testing.T) {
+	// The source emits one item and then blocks, so only the timer can
+	// flush it.
+	src := func(ctx context.Context, emit func(int) bool) error {
+		emit(1)
+		<-ctx.Done()
+		return nil
+	}
+	flushed := make(chan []int, 1)
+	flow := ThroughBatch(From("src", Source[int](src)), "batch", 10, 10*time.Millisecond,
+		func(ctx context.Context, batch []int) ([]int, []error) {
+			flushed <- batch
+			return batch, make([]error, len(batch))
+		})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := flow.To("discard", func(context.Context, int) error { return nil }).Run(ctx)
+
+	select {
+	case batch := <-flushed:
+		if !slices.Equal(batch, []int{1}) {
+			t.Errorf("flushed %v, want [1]", batch)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch not flushed after maxLatency")
+	}
+	cancel()
+	drain(t, errs)
+}
+
+func TestOrderedOutOfOrderWorkers(t *testing.T) {
+	const n = 20
+
+	// Item 0 is held back until three later items have finished, so the
+	// workers are guaranteed to complete out of order.
+	var (
+		mu       sync.Mutex
+		finished []int
+		release  = make(chan struct{})
+	)
+	stage := func(ctx context.Context, v int) (int, error) {
+		if v == 0 {
+			<-release
+		}
+		mu.Lock()
+		finished = append(finished, v)
+		if len(finished) == 3 {
+			close(release)
+		}
+		mu.Unlock()
+
+		if v == 5 {
+			return 0, errOdd
+		}
+		return v, nil
+	}
+
+	var got []int
+	flow := Through(From("count", count(n)), "stage", stage, WithWorkers(4), Ordered())
+	errs := drain(t, flow.To("collect", collect(&mu, &got)).Run(context.Background()))
+
+	if finished[0] == 0 {
+		t.Fatalf("item 0 finished first; the test did not exercise reordering")
+	}
+	want := make([]int, 0, n)
+	for i := range n {
+		if i != 5 {
+			want = append(want, i)
+		}
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %v, want input order without the failed item: %v", got, want)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("errors %v, want one for item 5", errs)
+	}
+}
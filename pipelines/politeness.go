@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/pipelines/pipeline"
+)
+
+const (
+	// robotsTTL is how long a fetched robots.txt is trusted.
+	robotsTTL = 24 * time.Hour
+	// maxRobotsSize is how much of a robots.txt is read; RFC 9309 asks
+	// crawlers to parse at least 500 KiB.
+	maxRobotsSize = 512 << 10
+)
+
+var errDisallowedByRobots = errors.New("disallowed by robots.txt")
+
+// robotsRule is one Allow or Disallow line. Patterns may use * for any
+// run of characters and end with $ to anchor at the end of the path.
+type robotsRule struct {
+	allow   bool
+	pattern string
+	re      *regexp.Regexp
+}
+
+func newRobotsRule(allow bool, pattern string) robotsRule {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	if strings.HasSuffix(expr, `\$`) {
+		expr = strings.TrimSuffix(expr, `\$`) + "$"
+	}
+	return robotsRule{allow: allow, pattern: pattern, re: regexp.MustCompile("^" + expr)}
+}
+
+// robotsRules is what a robots.txt says about our user agent.
+type robotsRules struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// allowed applies the most specific, i.e. longest, matching rule. Allow
+// wins a tie, and a path no rule matches is allowed.
+func (r *robotsRules) allowed(path string) bool {
+	var (
+		best    = -1
+		allowed = true
+	)
+	for _, rule := range r.rules {
+		if !rule.re.MatchString(path) {
+			continue
+		}
+		if n := len(rule.pattern); n > best || (n == best && rule.allow) {
+			best, allowed = n, rule.allow
+		}
+	}
+	return allowed
+}
+
+// parseRobots reads the groups in a robots.txt that apply to agent, a
+// product token: every group naming it, or else the * groups.
+func parseRobots(r io.Reader, agent string) *robotsRules {
+	type group struct {
+		agents     []string
+		rules      []robotsRule
+		crawlDelay time.Duration
+	}
+
+	var (
+		groups  []*group
+		current *group
+		// inAgents is set while reading a group's User-agent lines.
+		inAgents bool
+	)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		if key == "user-agent" {
+			if !inAgents {
+				current = &group{}
+				groups = append(groups, current)
+				inAgents = true
+			}
+			if value != "" {
+				current.agents = append(current.agents, strings.ToLower(value))
+			}
+			continue
+		}
+		inAgents = false
+		if current == nil {
+			continue
+		}
+
+		switch key {
+		case "allow", "disallow":
+			// An empty Disallow allows everything, so it adds nothing.
+			if value != "" {
+				current.rules = append(current.rules, newRobotsRule(key == "allow", value))
+			}
+		case "crawl-delay":
+			if secs, err := strconv.ParseFloat(value, 64); err == nil && secs > 0 {
+				current.crawlDelay = time.Duration(secs * float64(time.Second))
+			}
+		}
+	}
+
+	pick := func(match func(string) bool) *robotsRules {
+		var rules *robotsRules
+		for _, g := range groups {
+			for _, a := range g.agents {
+				if match(a) {
+					if rules == nil {
+						rules = &robotsRules{}
+					}
+					rules.rules = append(rules.rules, g.rules...)
+					rules.crawlDelay = max(rules.crawlDelay, g.crawlDelay)
+					break
+				}
+			}
+		}
+		return rules
+	}
+
+	// Groups name a product token, which is matched exactly and ignoring
+	// case: a group for "scrape" says nothing about "scraper".
+	agent = strings.ToLower(agent)
+	if agent != "" && agent != "*" {
+		if rules := pick(func(a string) bool { return a == agent }); rules != nil {
+			return rules
+		}
+	}
+	if rules := pick(func(a string) bool { return a == "*" }); rules != nil {
+		return rules
+	}
+	return &robotsRules{}
+}
+
+// productToken is the name robots.txt files address a user agent by, e.g.
+// "scraper" for "scraper/1.0 (+https://example.com)".
+func productToken(userAgent string) string {
+	token, _, _ := strings.Cut(userAgent, "/")
+	token, _, _ = strings.Cut(token, " ")
+	return token
+}
+
+// politeHost is what the scheduler knows about one host.
+type politeHost struct {
+	mu        sync.Mutex
+	robots    *robotsRules
+	fetchedAt time.Time
+	// loading is closed once the robots.txt fetch in progress finishes.
+	loading chan struct{}
+	// next is the earliest a new request to the host may start.
+	next time.Time
+}
+
+// politeScheduler decides when a URL may be fetched: only if the host's
+// robots.txt allows it, with at most maxPerHost requests to the host at a
+// time, and with at least minDelay, or the host's Crawl-delay if longer,
+// between the starts of two requests. The robots.txt fetch itself counts
+// as a request to the host, spaced by minDelay.
+type politeScheduler struct {
+	// client makes every request for the scraper, and userAgent is sent
+	// with each of them.
+	client    *http.Client
+	userAgent string
+	minDelay  time.Duration
+	limits    *hostLimiter
+
+	mu    sync.Mutex
+	hosts map[string]*politeHost
+}
+
+func newPoliteScheduler(client *http.Client, userAgent string, maxPerHost int, minDelay time.Duration) *politeScheduler {
+	return &politeScheduler{
+		client:    client,
+		userAgent: userAgent,
+		minDelay:  minDelay,
+		limits:    newHostLimiter(maxPerHost),
+		hosts:     make(map[string]*politeHost),
+	}
+}
+
+// hostKey is what both the per-host state and the per-host cap are keyed
+// by. robots.txt applies to one scheme, host and port, so that is a host.
+func hostKey(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
+
+func (s *politeScheduler) host(key string) *politeHost {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.hosts[key]
+	if !ok {
+		h = &politeHost{}
+		s.hosts[key] = h
+	}
+	return h
+}
+
+// robots returns the rules for u's host, fetching robots.txt if they are
+// missing or stale. Concurrent callers share one fetch. A failed fetch is
+// not cached, so the next caller tries again.
+func (s *politeScheduler) robots(ctx context.Context, u *url.URL) (*politeHost, *robotsRules, error) {
+	h := s.host(hostKey(u))
+
+	for {
+		h.mu.Lock()
+		if h.robots != nil && time.Since(h.fetchedAt) < robotsTTL {
+			rules := h.robots
+			h.mu.Unlock()
+			return h, rules, nil
+		}
+		if loading := h.loading; loading != nil {
+			h.mu.Unlock()
+			select {
+			case <-loading:
+				continue
+			case <-ctx.Done():
+				return nil, nil, context.Cause(ctx)
+			}
+		}
+		loading := make(chan struct{})
+		h.loading = loading
+		h.mu.Unlock()
+
+		var rules *robotsRules
+		release, err := s.reserve(ctx, u, h, s.minDelay)
+		if err == nil {
+			rules, err = s.fetchRobots(ctx, u)
+			release()
+		}
+
+		h.mu.Lock()
+		h.loading = nil
+		if err == nil {
+			h.robots, h.fetchedAt = rules, time.Now()
+		}
+		close(loading)
+		h.mu.Unlock()
+
+		return h, rules, err
+	}
+}
+
+// fetchRobots fetches and parses robots.txt for u's host. Following RFC
+// 9309, a 4xx means there are no rules; a 5xx or network failure is an
+// error, since the site may well have rules we could not read.
+func (s *politeScheduler) fetchRobots(ctx context.Context, u *url.URL) (*robotsRules, error) {
+	robotsURL := (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/robots.txt"}).String()
+
+	reqCtx, cancel := context.WithTimeoutCause(ctx, requestTimeout, errRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, robotsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", s.userAgent)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, &TransportError{URL: robotsURL, Err: pipeline.WithCause(ctx, err)}
+		}
+		return nil, &retryableError{err: &TransportError{URL: robotsURL, Err: pipeline.WithCause(reqCtx, err)}}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return parseRobots(io.LimitReader(resp.Body, maxRobotsSize), productToken(s.userAgent)), nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return &robotsRules{}, nil
+	}
+
+	statusErr := &HTTPStatusError{
+		URL:        robotsURL,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		RetryAfter: parseRetryAfter(resp.Header),
+	}
+	return nil, &retryableError{err: statusErr, retryAfter: statusErr.RetryAfter}
+}
+
+// acquire waits until rawURL may be fetched and returns the function that
+// must be called once the request is done. It fails with
+// errDisallowedByRobots if robots.txt forbids the URL.
+func (s *politeScheduler) acquire(ctx context.Context, rawURL string) (func(), error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	h, rules, err := s.robots(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	if !rules.allowed(requestPath(u)) {
+		return nil, fmt.Errorf("GET %s: %w", rawURL, errDisallowedByRobots)
+	}
+
+	return s.reserve(ctx, u, h, max(s.minDelay, rules.crawlDelay))
+}
+
+// reserve takes one of the request slots for u's host, whose state is h,
+// and waits until delay has passed since the start of the request before.
+// It returns the function that gives the slot back.
+func (s *politeScheduler) reserve(ctx context.Context, u *url.URL, h *politeHost, delay time.Duration) (func(), error) {
+	release, err := s.limits.acquire(ctx, hostKey(u))
+	if err != nil {
+		return nil, err
+	}
+
+	// Book a start time while holding the lock, so that concurrent
+	// requests to the host line up delay apart.
+	h.mu.Lock()
+	prev := h.next
+	start := time.Now()
+	if prev.After(start) {
+		start = prev
+	}
+	h.next = start.Add(delay)
+	h.mu.Unlock()
+
+	if wait := time.Until(start); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			// Give the booking back unless someone has booked after us,
+			// so that an abandoned request does not delay the next one.
+			h.mu.Lock()
+			if h.next.Equal(start.Add(delay)) {
+				h.next = prev
+			}
+			h.mu.Unlock()
+			release()
+			return nil, context.Cause(ctx)
+		}
+	}
+
+	return release, nil
+}
+
+// requestPath is the part of u robots.txt rules are matched against.
+func requestPath(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return path
+}
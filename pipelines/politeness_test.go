@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRobots(t *testing.T) {
+	const robots = `
+# Everybody else
+User-agent: *
+Disallow: /private
+Crawl-delay: 5
+
+User-agent: scrape
+Disallow: /
+
+User-agent:
+Disallow: /empty-agent
+
+User-agent: Scraper
+User-agent: other-bot
+Disallow: /search
+Allow: /search/public
+Disallow: /*.json$
+Allow: /todos
+Disallow: /todos
+Disallow: /drafts/*/edit
+Crawl-delay: 0.5
+`
+
+	tests := []struct {
+		agent     string
+		path      string
+		allowed   bool
+		wantDelay time.Duration
+	}{
+		// The Scraper group applies: exact, case-insensitive match.
+		{agent: "scraper", path: "/", allowed: true, wantDelay: 500 * time.Millisecond},
+		{agent: "scraper", path: "/private", allowed: true, wantDelay: 500 * time.Millisecond},
+		{agent: "SCRAPER", path: "/search/all", allowed: false, wantDelay: 500 * time.Millisecond},
+		// The longer Allow beats the shorter Disallow.
+		{agent: "scraper", path: "/search/public/1", allowed: true, wantDelay: 500 * time.Millisecond},
+		// Allow wins a tie.
+		{agent: "scraper", path: "/todos/1", allowed: true, wantDelay: 500 * time.Millisecond},
+		// * matches any run of characters, $ anchors at the end.
+		{agent: "scraper", path: "/data/todos.json", allowed: false, wantDelay: 500 * time.Millisecond},
+		{agent: "scraper", path: "/data/todos.json?page=2", allowed: true, wantDelay: 500 * time.Millisecond},
+		{agent: "scraper", path: "/drafts/7/edit", allowed: false, wantDelay: 500 * time.Millisecond},
+		{agent: "scraper", path: "/drafts/7/view", allowed: true, wantDelay: 500 * time.Millisecond},
+		// "scrape" is not "scraper", and an agent does not match a group
+		// whose name contains it either: both fall back to *.
+		{agent: "scrap", path: "/", allowed: true, wantDelay: 5 * time.Second},
+		{agent: "scraperbot", path: "/private", allowed: false, wantDelay: 5 * time.Second},
+		{agent: "scrape", path: "/todos", allowed: false},
+		// An empty User-agent line names nobody.
+		{agent: "", path: "/empty-agent", allowed: true, wantDelay: 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		rules := parseRobots(strings.NewReader(robots), tt.agent)
+		if got := rules.allowed(tt.path); got != tt.allowed {
+			t.Errorf("agent %q: allowed(%q) = %v, want %v", tt.agent, tt.path, got, tt.allowed)
+		}
+		if rules.crawlDelay != tt.wantDelay {
+			t.Errorf("agent %q: crawl delay = %v, want %v", tt.agent, rules.crawlDelay, tt.wantDelay)
+		}
+	}
+}
+
+func TestParseRobotsNoGroups(t *testing.T) {
+	rules := parseRobots(strings.NewReader("Disallow: /\nSitemap: https://example.com/sitemap.xml\n"), "scraper")
+	if !rules.allowed("/anything") {
+		t.Fatal("rules outside any group applied")
+	}
+}
+
+func TestFetchRobotsStatus(t *testing.T) {
+	tests := []struct {
+		status    int
+		body      string
+		allowed   bool
+		wantError bool
+	}{
+		{status: http.StatusOK, body: "User-agent: *\nDisallow: /todos\n", allowed: false},
+		{status: http.StatusNotFound, allowed: true},
+		{status: http.StatusForbidden, allowed: true},
+		{status: http.StatusServiceUnavailable, wantError: true},
+	}
+
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			fmt.Fprint(w, tt.body)
+		}))
+
+		s := newPoliteScheduler(http.DefaultClient, "scraper/1.0", 1, 0)
+		u, _ := url.Parse(srv.URL + "/todos/1")
+		rules, err := s.fetchRobots(context.Background(), u)
+		srv.Close()
+
+		if tt.wantError {
+			var re *retryableError
+			var se *HTTPStatusError
+			if !errors.As(err, &re) || !errors.As(err, &se) || se.StatusCode != tt.status {
+				t.Errorf("status %d: fetchRobots = %v, want a retryable *HTTPStatusError", tt.status, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("status %d: fetchRobots = %v", tt.status, err)
+			continue
+		}
+		if got := rules.allowed("/todos/1"); got != tt.allowed {
+			t.Errorf("status %d: allowed = %v, want %v", tt.status, got, tt.allowed)
+		}
+	}
+}
+
+func TestUserAgentOnEveryRequest(t *testing.T) {
+	quietLog(t)
+	// Not the -user-agent default: every request must take the agent from
+	// the scheduler.
+	const ua = "test-scraper/2.0 (+https://example.com)"
+
+	var (
+		mu     sync.Mutex
+		agents = make(map[string]string)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agents[r.URL.Path] = r.UserAgent()
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprint(w, "User-agent: test-scraper\nAllow: /\n")
+		case "/todos":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `[]`)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"id": 1, "userId": 1, "title": "a"}`)
+		}
+	}))
+	defer srv.Close()
+
+	sched := newPoliteScheduler(http.DefaultClient, ua, 1, 0)
+	if _, err := emitted(t, context.Background(), paginatedSource(sched, srv.URL+"/todos?page={page}", srv.URL+"/todos/{id}", retryPolicy{maxAttempts: 1})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := doHTTP(retryPolicy{maxAttempts: 1}, sched)(context.Background(), srv.URL+"/todos/1"); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, path := range []string{"/robots.txt", "/todos", "/todos/1"} {
+		if got := agents[path]; got != ua {
+			t.Errorf("GET %s sent User-Agent %q, want %q", path, got, ua)
+		}
+	}
+}
+
+func TestPoliteSchedulerPerHost(t *testing.T) {
+	tests := []struct {
+		name       string
+		robots     string
+		maxPerHost int
+		minDelay   time.Duration
+		// wantGap is the least time between two request starts.
+		wantGap time.Duration
+	}{
+		{name: "min delay", maxPerHost: 2, minDelay: 30 * time.Millisecond, wantGap: 30 * time.Millisecond},
+		{name: "crawl delay", robots: "User-agent: *\nCrawl-delay: 0.04\n", maxPerHost: 3, minDelay: 10 * time.Millisecond, wantGap: 40 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var robotsFetches atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/robots.txt" || tt.robots == "" {
+					http.NotFound(w, r)
+					return
+				}
+				robotsFetches.Add(1)
+				fmt.Fprint(w, tt.robots)
+			}))
+			defer srv.Close()
+
+			const requests = 6
+			s := newPoliteScheduler(http.DefaultClient, "scraper/1.0", tt.maxPerHost, tt.minDelay)
+
+			var (
+				wg                  sync.WaitGroup
+				inFlight, maxFlight atomic.Int32
+				mu                  sync.Mutex
+				starts              []time.Time
+				begin               = time.Now()
+				errs                = make(chan error, requests)
+			)
+			for i := range requests {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					release, err := s.acquire(context.Background(), fmt.Sprintf("%s/todos/%d", srv.URL, i))
+					if err != nil {
+						errs <- err
+						return
+					}
+					defer release()
+
+					n := inFlight.Add(1)
+					for {
+						m := maxFlight.Load()
+						if n <= m || maxFlight.CompareAndSwap(m, n) {
+							break
+						}
+					}
+					mu.Lock()
+					starts = append(starts, time.Now())
+					mu.Unlock()
+
+					// Hold the slot long enough for the others to pile up.
+					time.Sleep(3 * tt.wantGap)
+					inFlight.Add(-1)
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Fatal(err)
+			}
+
+			if got := maxFlight.Load(); got > int32(tt.maxPerHost) {
+				t.Errorf("%d requests in flight to one host, want at most %d", got, tt.maxPerHost)
+			}
+			// Starts are booked wantGap apart, so the last one cannot begin
+			// before requests-1 gaps have passed.
+			last := slices.MaxFunc(starts, time.Time.Compare)
+			if elapsed := last.Sub(begin); elapsed < (requests-1)*tt.wantGap {
+				t.Errorf("last request started after %v, want at least %v", elapsed, (requests-1)*tt.wantGap)
+			}
+			if tt.robots != "" && robotsFetches.Load() != 1 {
+				t.Errorf("robots.txt fetched %d times, want once", robotsFetches.Load())
+			}
+		})
+	}
+}
+
+func TestPoliteSchedulerDisallowed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "User-agent: scraper\nDisallow: /private\n")
+	}))
+	defer srv.Close()
+
+	s := newPoliteScheduler(http.DefaultClient, "scraper/1.0", 1, 0)
+	if _, err := s.acquire(context.Background(), srv.URL+"/private/1"); !errors.Is(err, errDisallowedByRobots) {
+		t.Fatalf("acquire = %v, want errDisallowedByRobots", err)
+	}
+	release, err := s.acquire(context.Background(), srv.URL+"/todos/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestPoliteSchedulerHostKey(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	s := newPoliteScheduler(http.DefaultClient, "scraper/1.0", 1, 0)
+	release, err := s.acquire(context.Background(), srv.URL+"/todos/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	// The cap and the robots.txt and delay state are kept per the same
+	// host, or a crawl delay could be shared by requests the cap treats
+	// as going to different hosts.
+	var hosts, limited []string
+	for key := range s.hosts {
+		hosts = append(hosts, key)
+	}
+	for key := range s.limits.hosts {
+		limited = append(limited, key)
+	}
+	if want := []string{srv.URL}; !slices.Equal(hosts, want) || !slices.Equal(limited, want) {
+		t.Fatalf("scheduler keys %q and host limiter keys %q, want both %q", hosts, limited, want)
+	}
+}
+
+// TestAcquireCanceledGivesBackItsStart checks that a request that gives up
+// while waiting for its turn does not push back the one after it.
+func TestAcquireCanceledGivesBackItsStart(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	const delay = time.Hour
+	s := newPoliteScheduler(http.DefaultClient, "scraper/1.0", 2, delay)
+	// Fetching robots.txt would book a start of its own, an hour before
+	// the first request; preload empty rules instead.
+	u, _ := url.Parse(srv.URL)
+	h := s.host(hostKey(u))
+	h.robots, h.fetchedAt = &robotsRules{}, time.Now()
+
+	release, err := s.acquire(context.Background(), srv.URL+"/todos/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	h.mu.Lock()
+	booked := h.next
+	h.mu.Unlock()
+
+	waitCtx, cancelWait := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelWait()
+	if _, err := s.acquire(waitCtx, srv.URL+"/todos/2"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acquire = %v, want context.DeadlineExceeded while waiting out the delay", err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.next.Equal(booked) {
+		t.Fatalf("next start moved by %v after a canceled acquire, want it unchanged", h.next.Sub(booked))
+	}
+}
+
+// TestRobotsFetchIsScheduled checks that robots.txt is fetched like any
+// other request: within the per-host cap and followed by the delay.
+func TestRobotsFetchIsScheduled(t *testing.T) {
+	var robotsFetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			robotsFetches.Add(1)
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	const delay = 50 * time.Millisecond
+	s := newPoliteScheduler(http.DefaultClient, "scraper/1.0", 1, delay)
+
+	// With the only slot taken, not even robots.txt may be fetched.
+	held, err := s.limits.acquire(context.Background(), hostKey(u))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := s.acquire(ctx, srv.URL+"/todos/1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acquire = %v with the host's slot taken, want context.DeadlineExceeded", err)
+	}
+	if n := robotsFetches.Load(); n != 0 {
+		t.Fatalf("robots.txt fetched %d times with the host's slot taken, want 0", n)
+	}
+	held()
+
+	// Once fetched, the request itself waits out the delay after it.
+	start := time.Now()
+	release, err := s.acquire(context.Background(), srv.URL+"/todos/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if n := robotsFetches.Load(); n != 1 {
+		t.Fatalf("robots.txt fetched %d times, want once", n)
+	}
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Fatalf("request started %v after robots.txt, want at least %v", elapsed, delay)
+	}
+}
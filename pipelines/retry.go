@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryPolicy describes how often and how patiently a fetch is retried.
+// Delays use "full jitter": a random duration between zero and
+// min(maxDelay, baseDelay*2^attempt), which keeps many workers that failed
+// together from retrying in lockstep.
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+var defaultRetryPolicy = retryPolicy{
+	maxAttempts: 4,
+	baseDelay:   200 * time.Millisecond,
+	maxDelay:    5 * time.Second,
+}
+
+// backoff returns the delay before retry number attempt (starting at 1).
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.maxDelay
+	if shift := attempt - 1; shift < 32 {
+		if d := p.baseDelay << shift; d > 0 && d < ceiling {
+			ceiling = d
+		}
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(ceiling) + 1))
+}
+
+// retryableError marks a failure worth another attempt. retryAfter is the
+// server's Retry-After hint, if it sent one.
+type retryableError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+// attemptsError is returned once a retried call gives up, so that logs
+// show how hard we tried.
+type attemptsError struct {
+	attempts int
+	err      error
+}
+
+func (e *attemptsError) Error() string {
+	return fmt.Sprintf("%v (after %d attempts)", e.err, e.attempts)
+}
+
+func (e *attemptsError) Unwrap() error {
+	return e.err
+}
+
+// retry calls fn until it succeeds, fails with an error that is not
+// retryable, runs out of attempts or ctx is done. A server's Retry-After
+// is honoured up to maxDelay, and retry gives up straight away when the
+// next attempt would start past ctx's deadline. It returns the number of
+// attempts made.
+func retry[T any](ctx context.Context, p retryPolicy, fn func(ctx context.Context) (T, error)) (T, int, error) {
+	var (
+		zero    T
+		lastErr error
+	)
+
+	for attempt := 1; ; attempt++ {
+		res, err := fn(ctx)
+		if err == nil {
+			return res, attempt, nil
+		}
+		lastErr = err
+
+		var re *retryableError
+		if !errors.As(err, &re) || attempt >= p.maxAttempts {
+			return zero, attempt, &attemptsError{attempts: attempt, err: lastErr}
+		}
+
+		delay := max(p.backoff(attempt), min(re.retryAfter, p.maxDelay))
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return zero, attempt, &attemptsError{attempts: attempt, err: lastErr}
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return zero, attempt, &attemptsError{attempts: attempt, err: errors.Join(lastErr, context.Cause(ctx))}
+		}
+	}
+}
+
+// isRetryableStatus reports whether a response status is worth retrying:
+// 429 Too Many Requests and any 5xx.
+func isRetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// parseRetryAfter understands both forms of the Retry-After header: a
+// number of seconds or an HTTP date.
+func parseRetryAfter(h http.Header) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
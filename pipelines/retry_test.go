@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoffBounds(t *testing.T) {
+	p := retryPolicy{baseDelay: 10 * time.Millisecond, maxDelay: 100 * time.Millisecond}
+	tests := []struct {
+		attempt int
+		ceiling time.Duration
+	}{
+		{1, 10 * time.Millisecond},
+		{2, 20 * time.Millisecond},
+		{4, 80 * time.Millisecond},
+		{5, 100 * time.Millisecond},
+		{40, 100 * time.Millisecond},
+		// Shifts that overflow still stop at maxDelay.
+		{70, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		var longest time.Duration
+		for range 1000 {
+			d := p.backoff(tt.attempt)
+			if d < 0 || d > tt.ceiling {
+				t.Fatalf("backoff(%d) = %v, want within [0, %v]", tt.attempt, d, tt.ceiling)
+			}
+			longest = max(longest, d)
+		}
+		// Full jitter spreads over the whole range, not just the bottom.
+		if longest < tt.ceiling/2 {
+			t.Errorf("backoff(%d) never went above %v in 1000 draws, want up to %v", tt.attempt, longest, tt.ceiling)
+		}
+	}
+
+	if d := (retryPolicy{}).backoff(1); d != 0 {
+		t.Errorf("backoff with no delays = %v, want 0", d)
+	}
+}
+
+// fastRetries keeps the retry tests quick.
+var fastRetries = retryPolicy{maxAttempts: 3, baseDelay: time.Millisecond, maxDelay: 2 * time.Millisecond}
+
+// statusServer answers every request with status and counts them.
+func statusServer(t *testing.T, status int, header http.Header) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func TestRetryStatuses(t *testing.T) {
+	tests := []struct {
+		status   int
+		attempts int
+	}{
+		{http.StatusTooManyRequests, 3},
+		{http.StatusInternalServerError, 3},
+		{http.StatusBadGateway, 3},
+		{http.StatusServiceUnavailable, 3},
+		{http.StatusBadRequest, 1},
+		{http.StatusUnauthorized, 1},
+		{http.StatusForbidden, 1},
+		{http.StatusNotFound, 1},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			srv, hits := statusServer(t, tt.status, nil)
+
+			_, attempts, err := retry(context.Background(), fastRetries, func(ctx context.Context) (*Todo, error) {
+				var todo Todo
+				return &todo, getJSON(ctx, http.DefaultClient, "scraper/1.0", srv.URL, defaultResponsePolicy, &todo)
+			})
+
+			var se *HTTPStatusError
+			if !errors.As(err, &se) || se.StatusCode != tt.status {
+				t.Fatalf("retry = %v, want an *HTTPStatusError for %d", err, tt.status)
+			}
+			var ae *attemptsError
+			if !errors.As(err, &ae) || ae.attempts != tt.attempts {
+				t.Fatalf("retry = %v, want an *attemptsError after %d attempts", err, tt.attempts)
+			}
+			if attempts != tt.attempts || int(hits.Load()) != tt.attempts {
+				t.Fatalf("made %d attempts and %d requests, want %d", attempts, hits.Load(), tt.attempts)
+			}
+		})
+	}
+}
+
+func TestRetryNetworkError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	_, attempts, err := retry(context.Background(), fastRetries, func(ctx context.Context) (struct{}, error) {
+		var v struct{}
+		return v, getJSON(ctx, http.DefaultClient, "scraper/1.0", url, defaultResponsePolicy, &v)
+	})
+	var te *TransportError
+	if !errors.As(err, &te) || attempts != fastRetries.maxAttempts {
+		t.Fatalf("retry = %v after %d attempts, want a *TransportError after %d", err, attempts, fastRetries.maxAttempts)
+	}
+}
+
+func TestRetrySucceedsAfterFailures(t *testing.T) {
+	calls := 0
+	got, attempts, err := retry(context.Background(), fastRetries, func(ctx context.Context) (int, error) {
+		calls++
+		if calls < 3 {
+			return 0, &retryableError{err: errors.New("flaky")}
+		}
+		return 42, nil
+	})
+	if got != 42 || attempts != 3 || err != nil {
+		t.Fatalf("retry = %d, %d, %v; want 42, 3, nil", got, attempts, err)
+	}
+}
+
+// TestRetryAfterClamped checks that a server asking for a long pause
+// cannot stall a worker beyond maxDelay.
+func TestRetryAfterClamped(t *testing.T) {
+	srv, hits := statusServer(t, http.StatusServiceUnavailable, http.Header{"Retry-After": {"3600"}})
+
+	start := time.Now()
+	_, _, err := retry(context.Background(), fastRetries, func(ctx context.Context) (struct{}, error) {
+		var v struct{}
+		return v, getJSON(ctx, http.DefaultClient, "scraper/1.0", srv.URL, defaultResponsePolicy, &v)
+	})
+	if err == nil || hits.Load() != 3 {
+		t.Fatalf("retry = %v after %d requests, want an error after 3", err, hits.Load())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("retry took %v with Retry-After: 3600 and maxDelay %v", elapsed, fastRetries.maxDelay)
+	}
+}
+
+func TestRetryGivesUpBeforeDeadline(t *testing.T) {
+	p := retryPolicy{maxAttempts: 5, baseDelay: time.Millisecond, maxDelay: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	cause := errors.New("rate limited")
+	start := time.Now()
+	_, attempts, err := retry(ctx, p, func(ctx context.Context) (int, error) {
+		return 0, &retryableError{err: cause, retryAfter: time.Minute}
+	})
+	if attempts != 1 || !errors.Is(err, cause) {
+		t.Fatalf("retry = %v after %d attempts, want the last error after 1", err, attempts)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("retry slept %v although the pause ran past the deadline", elapsed)
+	}
+}
+
+func TestRetryCanceled(t *testing.T) {
+	p := retryPolicy{maxAttempts: 5, baseDelay: time.Hour, maxDelay: time.Hour}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cause := errors.New("shutting down")
+
+	calls := 0
+	_, attempts, err := retry(ctx, p, func(ctx context.Context) (int, error) {
+		calls++
+		// Cancel while retry sleeps before the second attempt.
+		time.AfterFunc(10*time.Millisecond, func() { cancel(cause) })
+		return 0, &retryableError{err: errors.New("boom")}
+	})
+	if calls != 1 || attempts != 1 {
+		t.Fatalf("made %d calls and counted %d attempts, want 1", calls, attempts)
+	}
+	if !errors.Is(err, cause) {
+		t.Fatalf("retry = %v, want it to carry the cancel cause", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{"missing", "", 0},
+		{"seconds", "3", 3 * time.Second},
+		{"zero", "0", 0},
+		{"negative", "-5", 0},
+		{"garbage", "soon", 0},
+		{"past date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.value != "" {
+			h.Set("Retry-After", tt.value)
+		}
+		if got := parseRetryAfter(h); got != tt.want {
+			t.Errorf("%s: parseRetryAfter(%q) = %v, want %v", tt.name, tt.value, got, tt.want)
+		}
+	}
+
+	// HTTP dates have one second resolution.
+	h := http.Header{"Retry-After": {time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)}}
+	if got := parseRetryAfter(h); got < 58*time.Second || got > time.Minute {
+		t.Errorf("parseRetryAfter(date a minute ahead) = %v, want about 1m", got)
+	}
+}
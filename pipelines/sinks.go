@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/VarthanV/go-concurrency-exercises/pipelines/pipeline"
+	"gorm.io/gorm"
+)
+
+// OutputSink is somewhere scraped todos end up. It is not to be confused
+// with Sink, the last stage of a pipeline.
+type OutputSink interface {
+	Name() string
+	// Write stores a batch and returns one error per item, nil for the
+	// items that were written.
+	Write(ctx context.Context, batch []*fetchResult) []error
+	// Flush makes everything written so far durable.
+	Flush() error
+	Close() error
+}
+
+// sinkError is an item failure in one OutputSink.
+type sinkError struct {
+	sink string
+	err  error
+}
+
+func (e *sinkError) Error() string {
+	return fmt.Sprintf("sink %s: %v", e.sink, e.err)
+}
+
+func (e *sinkError) Unwrap() error {
+	return e.err
+}
+
+// sqliteSink stores todos with insertInDB. Every batch is committed in its
+// own transaction, so Flush has nothing left to do.
+type sqliteSink struct {
+	insert pipeline.BatchStage[*fetchResult, *fetchResult]
+}
+
+func newSQLiteSink(db *gorm.DB) *sqliteSink {
+	return &sqliteSink{insert: insertInDB(db)}
+}
+
+func (s *sqliteSink) Name() string { return "sqlite" }
+
+func (s *sqliteSink) Write(ctx context.Context, batch []*fetchResult) []error {
+	_, errs := s.insert(ctx, batch)
+	return errs
+}
+
+func (s *sqliteSink) Flush() error { return nil }
+
+// Close leaves the database open; it belongs to the scraper.
+func (s *sqliteSink) Close() error { return nil }
+
+// fileSink appends records to a buffered file. Items that cannot be
+// encoded fail alone; once a write to the file fails, every later item in
+// the batch fails with it.
+type fileSink struct {
+	name string
+	file *os.File
+	buf  *bufio.Writer
+	// encode writes one todo to buf.
+	encode func(*Todo) error
+	// flush pushes encode's own buffering, if any, into buf.
+	flush func() error
+}
+
+func (s *fileSink) Name() string { return s.name }
+
+func (s *fileSink) Write(ctx context.Context, batch []*fetchResult) []error {
+	errs := make([]error, len(batch))
+
+	var writeErr error
+	for i, res := range batch {
+		switch {
+		case writeErr != nil:
+			errs[i] = writeErr
+		case ctx.Err() != nil:
+			errs[i] = pipeline.WithCause(ctx, ctx.Err())
+		case res.Todo == nil:
+			errs[i] = &DecodeError{URL: res.URL, Err: errors.New("no todo to write")}
+		default:
+			if err := s.encode(res.Todo); err != nil {
+				errs[i] = err
+				if !isEncodingError(err) {
+					writeErr = err
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// Flush writes the buffer out and syncs the file to disk.
+func (s *fileSink) Flush() error {
+	if err := s.flush(); err != nil {
+		return err
+	}
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *fileSink) Close() error {
+	return errors.Join(s.Flush(), s.file.Close())
+}
+
+func isEncodingError(err error) bool {
+	var (
+		ue *json.UnsupportedValueError
+		te *json.UnsupportedTypeError
+		me *json.MarshalerError
+	)
+	return errors.As(err, &ue) || errors.As(err, &te) || errors.As(err, &me)
+}
+
+// openAppend opens path for appending and reports whether it was empty.
+func openAppend(path string) (*os.File, bool, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, false, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, false, err
+	}
+	return f, info.Size() == 0, nil
+}
+
+// newJSONLinesSink appends one JSON object per todo to path.
+func newJSONLinesSink(path string) (*fileSink, error) {
+	f, _, err := openAppend(path)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bufio.NewWriter(f)
+	enc := json.NewEncoder(buf)
+	return &fileSink{
+		name: "jsonl",
+		file: f,
+		buf:  buf,
+		encode: func(t *Todo) error {
+			return enc.Encode(t)
+		},
+		flush: func() error { return nil },
+	}, nil
+}
+
+var csvHeader = []string{"id", "user_id", "title", "completed"}
+
+// newCSVSink appends one row per todo to path, writing a header first if
+// the file is new.
+func newCSVSink(path string) (*fileSink, error) {
+	f, empty, err := openAppend(path)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bufio.NewWriter(f)
+	w := csv.NewWriter(buf)
+	if empty {
+		if err := w.Write(csvHeader); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return &fileSink{
+		name: "csv",
+		file: f,
+		buf:  buf,
+		encode: func(t *Todo) error {
+			return w.Write([]string{
+				strconv.Itoa(t.ID),
+				strconv.Itoa(t.UserID),
+				t.Title,
+				strconv.FormatBool(t.Completed),
+			})
+		},
+		flush: func() error {
+			w.Flush()
+			return w.Error()
+		},
+	}, nil
+}
+
+// openSinks opens the sinks named in the comma separated list names.
+func openSinks(names string, db *gorm.DB, jsonlPath, csvPath string) ([]OutputSink, error) {
+	var sinks []OutputSink
+
+	closeAll := func() {
+		for _, s := range sinks {
+			s.Close()
+		}
+	}
+
+	for _, name := range strings.Split(names, ",") {
+		var (
+			sink OutputSink
+			err  error
+		)
+		switch strings.TrimSpace(name) {
+		case "sqlite":
+			sink = newSQLiteSink(db)
+		case "jsonl":
+			sink, err = newJSONLinesSink(jsonlPath)
+		case "csv":
+			sink, err = newCSVSink(csvPath)
+		default:
+			err = fmt.Errorf("unknown sink %q, want sqlite, jsonl or csv", name)
+		}
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	return sinks, nil
+}
+
+// tee writes every batch to all sinks at once and flushes each of them
+// before the batch counts as done. An item fails if any sink fails it;
+// its error joins the *sinkError of every sink that did.
+//
+// Which sinks accepted an item is recorded in c, and a sink is only handed
+// the items it does not hold yet. A URL that failed in one sink is
+// therefore retried by a rerun in that sink alone, instead of being
+// appended again to the files that already have it. An item is recorded
+// once its sink has flushed it, so a crash in between can still leave a
+// duplicate behind.
+func tee(c *checkpointStore, sinks ...OutputSink) pipeline.BatchStage[*fetchResult, *fetchResult] {
+	return func(ctx context.Context, batch []*fetchResult) ([]*fetchResult, []error) {
+		perSink := make([][]error, len(sinks))
+
+		var wg sync.WaitGroup
+		for i, sink := range sinks {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				perSink[i] = deliver(ctx, c, sink, batch)
+			}()
+		}
+		wg.Wait()
+
+		errs := make([]error, len(batch))
+		for i, sink := range sinks {
+			for j, err := range perSink[i] {
+				if err != nil {
+					errs[j] = errors.Join(errs[j], &sinkError{sink: sink.Name(), err: err})
+				}
+			}
+		}
+		return batch, errs
+	}
+}
+
+// deliver writes the items of batch that sink does not hold yet and
+// returns one error per item of batch.
+func deliver(ctx context.Context, c *checkpointStore, sink OutputSink, batch []*fetchResult) []error {
+	errs := make([]error, len(batch))
+
+	urls := make([]string, len(batch))
+	for i, res := range batch {
+		urls[i] = res.URL
+	}
+	done, err := c.delivered(ctx, sink.Name(), urls)
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	var (
+		todo    []*fetchResult
+		indexes []int
+	)
+	for i, res := range batch {
+		if !done[res.URL] {
+			todo = append(todo, res)
+			indexes = append(indexes, i)
+		}
+	}
+	if len(todo) == 0 {
+		return errs
+	}
+
+	written := sink.Write(ctx, todo)
+	flushErr := sink.Flush()
+
+	var (
+		accepted   []string
+		acceptedAt []int
+	)
+	for k, i := range indexes {
+		// If the flush failed, nothing this sink accepted is safe yet.
+		errs[i] = errors.Join(written[k], flushErr)
+		if errs[i] == nil {
+			accepted = append(accepted, todo[k].URL)
+			acceptedAt = append(acceptedAt, i)
+		}
+	}
+
+	// Record the delivery even if the run is being cancelled: the items
+	// are in the sink now.
+	if err := c.markDelivered(context.WithoutCancel(ctx), sink.Name(), accepted); err != nil {
+		for _, i := range acceptedAt {
+			errs[i] = err
+		}
+	}
+	return errs
+}
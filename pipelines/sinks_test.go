@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// flakySink fails the URLs in failing and records every URL it is handed.
+type flakySink struct {
+	failing map[string]bool
+	written []string
+}
+
+func (s *flakySink) Name() string { return "flaky" }
+
+func (s *flakySink) Write(ctx context.Context, batch []*fetchResult) []error {
+	errs := make([]error, len(batch))
+	for i, res := range batch {
+		s.written = append(s.written, res.URL)
+		if s.failing[res.URL] {
+			errs[i] = errors.New("rejected")
+		}
+	}
+	return errs
+}
+
+func (s *flakySink) Flush() error { return nil }
+func (s *flakySink) Close() error { return nil }
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestTeeWritesEverySink(t *testing.T) {
+	quietLog(t)
+	db := openTestDB(t)
+	store, err := newCheckpointStore(db, maxFetchAttempts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	jsonlPath, csvPath := filepath.Join(dir, "todos.jsonl"), filepath.Join(dir, "todos.csv")
+	sinks, err := openSinks("sqlite,jsonl,csv", db, jsonlPath, csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch := []*fetchResult{todoResult(1, "a"), todoResult(2, "b")}
+	_, errs := tee(store, sinks...)(context.Background(), batch)
+	if err := errors.Join(errs...); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range sinks {
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var n int64
+	db.Model(&Todo{}).Count(&n)
+	if n != 2 {
+		t.Errorf("sqlite holds %d todos, want 2", n)
+	}
+	if lines := readLines(t, jsonlPath); len(lines) != 2 || !strings.Contains(lines[1], `"title":"b"`) {
+		t.Errorf("jsonl holds %q, want both todos", lines)
+	}
+	if lines := readLines(t, csvPath); len(lines) != 3 || lines[2] != "2,1,b,false" {
+		t.Errorf("csv holds %q, want a header and both todos", lines)
+	}
+}
+
+// TestTeeSkipsDeliveredSinks reruns a batch one sink failed part of: the
+// sinks that took the items must not get them a second time.
+func TestTeeSkipsDeliveredSinks(t *testing.T) {
+	store, err := newCheckpointStore(openTestDB(t), maxFetchAttempts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jsonlPath := filepath.Join(t.TempDir(), "todos.jsonl")
+	batch := []*fetchResult{todoResult(1, "a"), todoResult(2, "b")}
+	flaky := &flakySink{failing: map[string]bool{batch[1].URL: true}}
+
+	run := func() []error {
+		jsonl, err := newJSONLinesSink(jsonlPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer jsonl.Close()
+
+		_, errs := tee(store, jsonl, flaky)(context.Background(), batch)
+		return errs
+	}
+
+	errs := run()
+	var se *sinkError
+	if errs[0] != nil || !errors.As(errs[1], &se) || se.sink != "flaky" {
+		t.Fatalf("first run errors = %v, want only the second item failed by the flaky sink", errs)
+	}
+
+	flaky.failing = nil
+	if err := errors.Join(run()...); err != nil {
+		t.Fatalf("rerun = %v", err)
+	}
+
+	if lines := readLines(t, jsonlPath); len(lines) != 2 {
+		t.Errorf("jsonl holds %d lines after the rerun, want each todo once", len(lines))
+	}
+	want := []string{batch[0].URL, batch[1].URL, batch[1].URL}
+	if strings.Join(flaky.written, " ") != strings.Join(want, " ") {
+		t.Errorf("flaky sink was handed %q, want %q", flaky.written, want)
+	}
+}
+
+func TestCSVHeaderOnlyForNewFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todos.csv")
+
+	for id := 1; id <= 2; id++ {
+		sink, err := newCSVSink(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := todoResult(id, "t")
+		if err := errors.Join(sink.Write(context.Background(), []*fetchResult{res})...); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"id,user_id,title,completed", "1,1,t,false", "2,1,t,false"}
+	if got := readLines(t, path); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("csv holds %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/VarthanV/go-concurrency-exercises/pipelines/pipeline"
+)
+
+// rangePattern matches a {first..last} range in a URL template.
+var rangePattern = regexp.MustCompile(`\{(\d+)\.\.(\d+)\}`)
+
+// expandRanges calls emit for every URL a template stands for, so
+// /posts/{1..3} becomes /posts/1, /posts/2 and /posts/3. Several ranges
+// expand to their cross product, ranges may count down, and a first bound
+// with leading zeros, like {001..100}, pads every number to its width.
+// It returns false as soon as emit does.
+func expandRanges(tmpl string, emit func(string) bool) (bool, error) {
+	loc := rangePattern.FindStringSubmatchIndex(tmpl)
+	if loc == nil {
+		return emit(tmpl), nil
+	}
+
+	firstStr := tmpl[loc[2]:loc[3]]
+	first, err := strconv.Atoi(firstStr)
+	if err != nil {
+		return false, fmt.Errorf("range in %q: %w", tmpl, err)
+	}
+	last, err := strconv.Atoi(tmpl[loc[4]:loc[5]])
+	if err != nil {
+		return false, fmt.Errorf("range in %q: %w", tmpl, err)
+	}
+
+	width := 0
+	if len(firstStr) > 1 && firstStr[0] == '0' {
+		width = len(firstStr)
+	}
+	step := 1
+	if last < first {
+		step = -1
+	}
+
+	prefix, suffix := tmpl[:loc[0]], tmpl[loc[1]:]
+	for i := first; ; i += step {
+		ok, err := expandRanges(fmt.Sprintf("%s%0*d%s", prefix, width, i, suffix), emit)
+		if !ok || err != nil {
+			return ok, err
+		}
+		if i == last {
+			return true, nil
+		}
+	}
+}
+
+// generator emits urls with their ranges expanded.
+func generator(urls ...string) pipeline.Source[string] {
+	return func(ctx context.Context, emit func(string) bool) error {
+		for _, val := range urls {
+			ok, err := expandRanges(val, emit)
+			if !ok || err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// lineSource emits the URLs listed in r, one per line. Blank lines and
+// everything after a # are skipped, and ranges are expanded.
+//
+// Lines are read on a separate goroutine so that a canceled ctx returns
+// right away even while a read blocks, as it does on an idle stdin. That
+// goroutine exits after its current read, or at once if r is closed.
+func lineSource(r io.Reader) pipeline.Source[string] {
+	return func(ctx context.Context, emit func(string) bool) error {
+		var (
+			lines   = make(chan string)
+			scanErr = make(chan error, 1)
+			done    = make(chan struct{})
+		)
+		defer close(done)
+
+		go func() {
+			defer close(lines)
+			scanner := bufio.NewScanner(r)
+			for scanner.Scan() {
+				select {
+				case lines <- scanner.Text():
+				case <-done:
+					return
+				}
+			}
+			scanErr <- scanner.Err()
+		}()
+
+		for lineNo := 1; ; lineNo++ {
+			var (
+				line string
+				ok   bool
+			)
+			select {
+			case line, ok = <-lines:
+				if !ok {
+					return <-scanErr
+				}
+			case <-ctx.Done():
+				return nil
+			}
+
+			if i := strings.IndexByte(line, '#'); i >= 0 {
+				line = line[:i]
+			}
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+
+			ok, err := expandRanges(line, emit)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			if !ok {
+				return nil
+			}
+		}
+	}
+}
+
+// fileSource emits the URLs listed in the file at path, or on stdin if
+// path is "-". See lineSource for the format.
+func fileSource(path string) pipeline.Source[string] {
+	return func(ctx context.Context, emit func(string) bool) error {
+		if path == "-" {
+			return lineSource(os.Stdin)(ctx, emit)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return lineSource(f)(ctx, emit)
+	}
+}
+
+// paginatedSource discovers URLs from a paginated listing. pageURL is
+// fetched with {page} replaced by 1, 2, ... until a page comes back empty.
+// Every page must be a JSON array of objects with an id, and each id is
+// emitted as itemURL with {id} replaced. Pages are retried like fetches
+// and scheduled by sched like them, so discovery counts against the same
+// per-host limits and crawl delay.
+func paginatedSource(sched *politeScheduler, pageURL, itemURL string, policy retryPolicy) pipeline.Source[string] {
+	return func(ctx context.Context, emit func(string) bool) error {
+		for page := 1; ; page++ {
+			url := strings.ReplaceAll(pageURL, "{page}", strconv.Itoa(page))
+
+			ids, _, err := retry(ctx, policy, func(ctx context.Context) ([]json.Number, error) {
+				release, err := sched.acquire(ctx, url)
+				if err != nil {
+					return nil, err
+				}
+				defer release()
+
+				return fetchPageIDs(ctx, sched.client, sched.userAgent, url)
+			})
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("discover page %d: %w", page, err)
+			}
+			if len(ids) == 0 {
+				return nil
+			}
+
+			for _, id := range ids {
+				if !emit(strings.ReplaceAll(itemURL, "{id}", id.String())) {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// idPage is one page of a paginated listing.
+type idPage []struct {
+	ID json.Number `json:"id"`
+}
+
+func (p idPage) validate() error {
+	for _, item := range p {
+		if item.ID == "" {
+			return errors.New("page item without an id")
+		}
+	}
+	return nil
+}
+
+// fetchPageIDs makes a single attempt at fetching one listing page and
+// returns the ids on it.
+func fetchPageIDs(ctx context.Context, client *http.Client, userAgent, url string) ([]json.Number, error) {
+	var page idPage
+	if err := getJSON(ctx, client, userAgent, url, defaultResponsePolicy, &page); err != nil {
+		return nil, err
+	}
+
+	ids := make([]json.Number, 0, len(page))
+	for _, item := range page {
+		ids = append(ids, item.ID)
+	}
+	return ids, nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// emitted runs src to completion and returns what it emitted.
+func emitted(t *testing.T, ctx context.Context, src func(context.Context, func(string) bool) error) ([]string, error) {
+	t.Helper()
+	var got []string
+	err := src(ctx, func(u string) bool {
+		got = append(got, u)
+		return true
+	})
+	return got, err
+}
+
+func TestExpandRanges(t *testing.T) {
+	tests := []struct {
+		name string
+		tmpl string
+		want []string
+	}{
+		{name: "no range", tmpl: "/todos/1", want: []string{"/todos/1"}},
+		{name: "counting up", tmpl: "/todos/{1..3}", want: []string{"/todos/1", "/todos/2", "/todos/3"}},
+		{name: "counting down", tmpl: "/todos/{3..1}", want: []string{"/todos/3", "/todos/2", "/todos/1"}},
+		{name: "single number", tmpl: "/todos/{7..7}", want: []string{"/todos/7"}},
+		{name: "zero padding", tmpl: "/img/{008..011}.png", want: []string{"/img/008.png", "/img/009.png", "/img/010.png", "/img/011.png"}},
+		{name: "padding only from the first bound", tmpl: "/img/{8..011}", want: []string{"/img/8", "/img/9", "/img/10", "/img/11"}},
+		{
+			name: "cross product",
+			tmpl: "/users/{1..2}/posts/{3..1}",
+			want: []string{
+				"/users/1/posts/3", "/users/1/posts/2", "/users/1/posts/1",
+				"/users/2/posts/3", "/users/2/posts/2", "/users/2/posts/1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			ok, err := expandRanges(tt.tmpl, func(u string) bool {
+				got = append(got, u)
+				return true
+			})
+			if !ok || err != nil {
+				t.Fatalf("expandRanges(%q) = %v, %v", tt.tmpl, ok, err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("expandRanges(%q) emitted %q, want %q", tt.tmpl, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandRangesStops(t *testing.T) {
+	var got []string
+	ok, err := expandRanges("/users/{1..3}/posts/{1..3}", func(u string) bool {
+		got = append(got, u)
+		return len(got) < 4
+	})
+	if ok || err != nil {
+		t.Fatalf("expandRanges = %v, %v; want false, nil once emit returns false", ok, err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("emitted %d URLs after emit returned false, want 4", len(got))
+	}
+}
+
+func TestLineSource(t *testing.T) {
+	input := strings.Join([]string{
+		"# todos to fetch",
+		"https://example.com/todos/1",
+		"",
+		"   https://example.com/todos/2   # trailing comment",
+		"\t",
+		"https://example.com/todos/{3..4}",
+		"#https://example.com/todos/5",
+	}, "\n")
+
+	got, err := emitted(t, context.Background(), lineSource(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"https://example.com/todos/1",
+		"https://example.com/todos/2",
+		"https://example.com/todos/3",
+		"https://example.com/todos/4",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("lineSource emitted %q, want %q", got, want)
+	}
+}
+
+func TestLineSourceBadRange(t *testing.T) {
+	input := "https://example.com/todos/1\nhttps://example.com/todos/{1..99999999999999999999}\n"
+
+	_, err := emitted(t, context.Background(), lineSource(strings.NewReader(input)))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2: ") {
+		t.Fatalf("lineSource = %v, want an error for line 2", err)
+	}
+}
+
+// TestLineSourceCancelWhileBlocked covers an idle stdin: the read never
+// returns, but canceling must still stop the source.
+func TestLineSourceCancelWhileBlocked(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	emittedURL := make(chan string, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- lineSource(r)(ctx, func(u string) bool {
+			emittedURL <- u
+			return true
+		})
+	}()
+
+	if _, err := io.WriteString(w, "https://example.com/todos/1\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-emittedURL; got != "https://example.com/todos/1" {
+		t.Fatalf("emitted %q", got)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("lineSource = %v after cancel, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("lineSource did not return after cancel while its read was blocked")
+	}
+}
+
+func TestPaginatedSource(t *testing.T) {
+	pages := map[string]string{
+		"1": `[{"id": 1}, {"id": 2}]`,
+		"2": `[{"id": 3}]`,
+		"3": `[]`,
+		"4": `[{"id": 4}]`,
+	}
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		requests.Add(1)
+		page, ok := pages[r.URL.Query().Get("page")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, page)
+	}))
+	defer srv.Close()
+
+	src := paginatedSource(newPoliteScheduler(http.DefaultClient, "scraper/1.0", 1, 0),
+		srv.URL+"/todos?page={page}", "https://example.com/todos/{id}", retryPolicy{maxAttempts: 1})
+	got, err := emitted(t, context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"https://example.com/todos/1",
+		"https://example.com/todos/2",
+		"https://example.com/todos/3",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("paginatedSource emitted %q, want %q", got, want)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Fatalf("paginatedSource fetched %d pages, want it to stop after the empty page 3", n)
+	}
+}
+
+func TestPaginatedSourceBadPage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[{"title": "no id"}]`)
+	}))
+	defer srv.Close()
+
+	src := paginatedSource(newPoliteScheduler(http.DefaultClient, "scraper/1.0", 1, 0),
+		srv.URL+"/todos?page={page}", "https://example.com/todos/{id}", retryPolicy{maxAttempts: 1})
+	if _, err := emitted(t, context.Background(), src); err == nil || !strings.HasPrefix(err.Error(), "discover page 1: ") {
+		t.Fatalf("paginatedSource = %v, want an error for page 1", err)
+	}
+}
+
+// TestPaginatedSourceIsScheduled checks that listing pages go through the
+// polite scheduler like fetches: robots.txt applies, and pages to one host
+// are spaced by the crawl delay.
+func TestPaginatedSourceIsScheduled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/robots.txt":
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+		case r.URL.Query().Get("page") == "3":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `[]`)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `[{"id": 1}]`)
+		}
+	}))
+	defer srv.Close()
+
+	const delay = 50 * time.Millisecond
+	sched := newPoliteScheduler(http.DefaultClient, "scraper/1.0", 1, delay)
+
+	start := time.Now()
+	src := paginatedSource(sched, srv.URL+"/todos?page={page}", "https://example.com/todos/{id}", retryPolicy{maxAttempts: 1})
+	if _, err := emitted(t, context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	// Three pages, each starting delay after the one before.
+	if elapsed := time.Since(start); elapsed < 2*delay {
+		t.Fatalf("three pages took %v, want at least %v between them", elapsed, delay)
+	}
+
+	src = paginatedSource(sched, srv.URL+"/private?page={page}", "https://example.com/todos/{id}", retryPolicy{maxAttempts: 1})
+	if _, err := emitted(t, context.Background(), src); !errors.Is(err, errDisallowedByRobots) {
+		t.Fatalf("paginatedSource = %v, want errDisallowedByRobots", err)
+	}
+}
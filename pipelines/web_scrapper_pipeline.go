@@ -1,19 +1,87 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
-	"io"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
-	"sync"
+	"os"
+	"os/signal"
+	"time"
 
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+	"github.com/VarthanV/go-concurrency-exercises/pipelines/pipeline"
+	"github.com/VarthanV/go-concurrency-exercises/ratelimit"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
+var (
+	urlsFile = flag.String("urls", "",
+		"file with one URL per line, or - for stdin; # starts a comment and {1..500} style ranges are expanded")
+	discoverURL = flag.String("discover", "",
+		"paginated listing to discover URLs from, with a {page} placeholder, e.g. https://jsonplaceholder.typicode.com/todos?_page={page}&_limit=50")
+	sinkNames = flag.String("sinks", "sqlite",
+		"comma separated outputs to write todos to: sqlite, jsonl, csv")
+	jsonlOut  = flag.String("jsonl-out", "todos.jsonl", "file the jsonl sink appends to")
+	csvOut    = flag.String("csv-out", "todos.csv", "file the csv sink appends to")
+	userAgent = flag.String("user-agent", "go-concurrency-exercises-scraper/1.0",
+		"User-Agent sent with every request; its first word is matched against robots.txt groups")
+	crawlDelay = flag.Duration("crawl-delay", 250*time.Millisecond,
+		"minimum time between two requests to the same host; a longer robots.txt Crawl-delay wins")
+	requestRate = flag.Float64("rate", 0,
+		"most requests per second to send across all hosts; 0 means no limit")
+	metricsAddr = flag.String("metrics-addr", "",
+		"serve Prometheus metrics on this address under /metrics, e.g. localhost:9090; empty disables")
+	runTimeout = flag.Duration("timeout", 0,
+		"stop the whole run after this long; 0 means no limit")
+	discoverItemURL = flag.String("discover-item", "https://jsonplaceholder.typicode.com/todos/{id}",
+		"URL to scrape for every id found by -discover, with an {id} placeholder")
+	preserveFetchOrder = flag.Bool("ordered", false,
+		"store todos in URL order instead of as soon as each one is fetched")
+)
+
+// defaultURLs are scraped when neither -urls nor -discover is given.
+var defaultURLs = []string{
+	"https://jsonplaceholder.typicode.com/posts/{1..3}",
+	"https://bas",
+	"https://jsonplaceholder.typicode.com/posts/4",
+}
+
+const (
+	// scraperDBPath is the SQLite database holding todos, checkpoints and
+	// dead letters.
+	scraperDBPath = "todo.db"
+	// fetchWorkers is how many URLs doHTTP fetches at once.
+	fetchWorkers = 8
+	// maxRequestsPerHost caps the fetches in flight to any single host.
+	maxRequestsPerHost = 4
+	// insertBatchSize and insertMaxLatency bound how many todos the store
+	// stage collects, and for how long, before writing them to the sinks.
+	insertBatchSize  = 50
+	insertMaxLatency = 500 * time.Millisecond
+	// maxFetchAttempts is how many fetch attempts, summed over all runs, a
+	// failing URL gets before reruns skip it.
+	maxFetchAttempts = 12
+	// requestTimeout bounds a single HTTP attempt. fetchStageTimeout bounds
+	// all attempts for one URL, backoff included, and insertStageTimeout
+	// writing one batch to every sink.
+	requestTimeout     = 10 * time.Second
+	fetchStageTimeout  = 45 * time.Second
+	insertStageTimeout = 30 * time.Second
+	// Pipeline errors go to errorLogPath, which is rotated once it reaches
+	// errorLogMaxSize or errorLogMaxAge. The newest errorLogBackups rotated
+	// files are kept.
+	errorLogPath    = "errors.jsonl"
+	errorLogMaxSize = 10 << 20
+	errorLogMaxAge  = 24 * time.Hour
+	errorLogBackups = 5
+)
+
 type Todo struct {
 	UserID    int    `json:"userId"`
 	ID        int    `json:"id" gorm:"primaryKey" `
@@ -21,184 +89,358 @@ type Todo struct {
 	Completed bool   `json:"completed"`
 }
 
-type Process struct {
-	Todo *Todo
-	Err  error
+// fetchResult is what doHTTP hands to the next stage: the decoded todo
+// along with where it came from and how many attempts it took.
+type fetchResult struct {
+	URL      string
+	Todo     *Todo
+	Attempts int
 }
 
-func generator(done <-chan interface{}, urls ...string) <-chan string {
-	urlStream := make(chan string)
-
-	go func() {
-		defer close(urlStream)
-		for _, val := range urls {
-			select {
-			case urlStream <- val:
+// Stage 1
 
-			case <-done:
-				return
+// doHTTP fetches todos, asking sched before every attempt so that retries
+// are as polite as first tries.
+func doHTTP(policy retryPolicy, sched *politeScheduler) pipeline.Stage[string, *fetchResult] {
+	return func(ctx context.Context, url string) (*fetchResult, error) {
+		todo, attempts, err := retry(ctx, policy, func(ctx context.Context) (*Todo, error) {
+			release, err := sched.acquire(ctx, url)
+			if err != nil {
+				return nil, err
 			}
+			defer release()
+
+			return fetchTodo(ctx, sched.client, sched.userAgent, url)
+		})
+		if err != nil {
+			return nil, err
 		}
-	}()
+		return &fetchResult{URL: url, Todo: todo, Attempts: attempts}, nil
+	}
+}
 
-	return urlStream
+// validate rejects bodies such as null or {} that decode without error.
+func (t *Todo) validate() error {
+	if t.ID == 0 {
+		return errors.New("todo without an id")
+	}
+	return nil
 }
 
-// Stage 1
-func doHTTP(done <-chan interface{}, urlStream <-chan string) <-chan Process {
-	resultStream := make(chan Process)
+// fetchTodo makes a single attempt at fetching url. Failures are
+// *TransportError, *HTTPStatusError or *DecodeError, wrapped in
+// *retryableError when another attempt may succeed.
+func fetchTodo(ctx context.Context, client *http.Client, userAgent, url string) (*Todo, error) {
+	var todo Todo
 
-	go func() {
-		defer close(resultStream)
-
-		for {
-			select {
-			case url, ok := <-urlStream:
-				if !ok {
-					log.Println("all process complete")
-					return
-				}
-				var (
-					httpResp *Todo
-				)
+	log.Println("Fetching url ", url)
+	if err := getJSON(ctx, client, userAgent, url, defaultResponsePolicy, &todo); err != nil {
+		return nil, err
+	}
+	return &todo, nil
+}
 
-				log.Println("Fetching url ", url)
-				resp, err := http.Get(url)
-				if err != nil {
-					resultStream <- Process{
-						Err: err,
-					}
-					continue
-				}
+// Stage 2 insert in db
 
-				// Read request body
-				respBody, err := io.ReadAll(resp.Body)
-				if err != nil {
-					resultStream <- Process{
-						Err: err,
-					}
+// insertInDB stores a batch of todos in one transaction. The whole batch
+// is inserted with a single statement first; if that fails the batch is
+// replayed row by row, each behind a savepoint, so that one bad row only
+// fails its own item.
+func insertInDB(db *gorm.DB) pipeline.BatchStage[*fetchResult, *fetchResult] {
+	return func(ctx context.Context, batch []*fetchResult) ([]*fetchResult, []error) {
+		errs := make([]error, len(batch))
+
+		todos := make([]*Todo, 0, len(batch))
+		for i, res := range batch {
+			if res.Todo == nil {
+				errs[i] = &DecodeError{URL: res.URL, Err: errors.New("no todo to insert")}
+				continue
+			}
+			todos = append(todos, res.Todo)
+		}
+		if len(todos) == 0 {
+			return batch, errs
+		}
+
+		log.Println("inserting batch into db of size ", len(todos))
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&todos).Error
+		})
+		if err == nil {
+			return batch, errs
+		}
+
+		log.Println("batch insert failed, retrying row by row ", err)
+		err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			for i, res := range batch {
+				if res.Todo == nil {
 					continue
 				}
-
-				err = json.Unmarshal(respBody, &httpResp)
+				savepoint := fmt.Sprintf("todo_%d", i)
+				if err := tx.SavePoint(savepoint).Error; err != nil {
+					return err
+				}
+				err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(res.Todo).Error
 				if err != nil {
-					resultStream <- Process{
-						Err: err,
+					errs[i] = fmt.Errorf("insert todo %d fetched from %s after %d attempts: %w",
+						res.Todo.ID, res.URL, res.Attempts, err)
+					if err := tx.RollbackTo(savepoint).Error; err != nil {
+						return err
 					}
 				}
-
-				resultStream <- Process{
-					Todo: httpResp,
-				}
-
-			case <-done:
-				return
+			}
+			return nil
+		})
+		if err != nil {
+			// The transaction itself failed, so nothing was stored.
+			for i := range errs {
+				errs[i] = errors.Join(errs[i], err)
 			}
 		}
-	}()
+		return batch, errs
+	}
+}
 
-	return resultStream
+// logStored is the last stage: everything that reaches it is in every sink.
+func logStored(ctx context.Context, res *fetchResult) error {
+	if res.Todo != nil {
+		log.Printf("stored todo %d (fetched in %d attempts)\n", res.Todo.ID, res.Attempts)
+	}
+	return nil
 }
 
-// Stage 2 insert in db
+// scraper bundles the database and the stores every run needs.
+type scraper struct {
+	db          *gorm.DB
+	checkpoints *checkpointStore
+	deadLetters *deadLetterStore
+	errorLog    *errorLog
+	metrics     *pipeline.Metrics
+	sinks       []OutputSink
+	scheduler   *politeScheduler
+}
 
-func insertInDB(done <-chan interface{}, processStream <-chan Process, db *gorm.DB) <-chan Process {
-	resultStream := make(chan Process)
+// openDB opens the SQLite database at path and migrates the todos table.
+func openDB(path string) (*gorm.DB, error) {
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{
+		Logger: logger.Default,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("open db: %w", err)
+	}
 
-	go func() {
-		defer close(resultStream)
-
-		for {
-			select {
-			case val, ok := <-processStream:
-				if !ok {
-					log.Println("all process complete")
-					return
-				}
-				if val.Err != nil {
-					resultStream <- val
-					continue
-				}
+	// SQLite allows a single writer; funnel every stage through one
+	// connection instead of failing with "database is locked".
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
 
-				if val.Todo != nil {
-					log.Println("inserting into db with id ", val.Todo.ID)
-					err := db.Model(&Todo{}).
-						Clauses(clause.OnConflict{DoNothing: true}).
-						Create(&val.Todo).Error
-					if err != nil {
-						val.Err = errors.Join(val.Err, err)
-						resultStream <- val
-						continue
-					}
-					resultStream <- val
-				}
+	err = db.AutoMigrate(&Todo{})
+	if err != nil {
+		sqlDB.Close()
+		return nil, fmt.Errorf("automigrate: %w", err)
+	}
+	return db, nil
+}
 
-			case <-done:
-				return
-			}
+func openScraper(path string) (_ *scraper, err error) {
+	db, err := openDB(path)
+	if err != nil {
+		return nil, err
+	}
+	// Don't leak the database if a later step fails.
+	defer func() {
+		if err == nil {
+			return
+		}
+		if sqlDB, dbErr := db.DB(); dbErr == nil {
+			sqlDB.Close()
 		}
 	}()
 
-	return resultStream
-}
+	checkpoints, err := newCheckpointStore(db, maxFetchAttempts)
+	if err != nil {
+		return nil, fmt.Errorf("migrate checkpoints: %w", err)
+	}
 
-func WebScrapperPipelineDriver() {
-	var (
-		errChan = make(chan error, 5)
-		wg      sync.WaitGroup
-	)
+	deadLetters, err := newDeadLetterStore(db)
+	if err != nil {
+		return nil, fmt.Errorf("migrate dead letters: %w", err)
+	}
 
-	db, err := gorm.Open(sqlite.Open("todo.db"), &gorm.Config{
-		Logger: logger.Default,
-	})
+	errLog, err := newErrorLog(errorLogPath, errorLogMaxSize, errorLogMaxAge, errorLogBackups)
 	if err != nil {
-		log.Fatal("unable to open db ", err)
+		return nil, fmt.Errorf("open error log: %w", err)
 	}
 
-	err = db.AutoMigrate(&Todo{})
+	sinks, err := openSinks(*sinkNames, db, *jsonlOut, *csvOut)
 	if err != nil {
-		log.Fatal("unable to automigrate ", err)
-	}
-
-	done := make(chan interface{})
-	defer close(done)
-
-	urlStream := generator(done,
-		"https://jsonplaceholder.typicode.com/posts/1",
-		"https://jsonplaceholder.typicode.com/posts/2",
-		"https://jsonplaceholder.typicode.com/posts/3",
-		"https://bas",
-		"https://jsonplaceholder.typicode.com/posts/4",
-	)
-
-	logErrorToFile := func(done <-chan interface{}, errChan <-chan error) {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for err := range errChan {
-				select {
-				default:
-					log.Println(err)
-
-				case <-done:
-					return
-				}
-				// TODO: will log in file later
+		errLog.Close()
+		return nil, fmt.Errorf("open sinks: %w", err)
+	}
+
+	return &scraper{
+		db:          db,
+		checkpoints: checkpoints,
+		deadLetters: deadLetters,
+		errorLog:    errLog,
+		metrics:     pipeline.NewMetrics(),
+		sinks:       sinks,
+		scheduler:   newPoliteScheduler(newHTTPClient(*requestRate), *userAgent, maxRequestsPerHost, *crawlDelay),
+	}, nil
+}
+
+// Close flushes the sinks and the error log and closes the database.
+func (s *scraper) Close() error {
+	var errs []error
+	for _, sink := range s.sinks {
+		errs = append(errs, sink.Close())
+	}
+	errs = append(errs, s.errorLog.Close())
+	if sqlDB, err := s.db.DB(); err == nil {
+		errs = append(errs, sqlDB.Close())
+	}
+	return errors.Join(errs...)
+}
+
+var (
+	// errInterrupted is the cancellation cause when the user hits Ctrl-C.
+	errInterrupted = errors.New("interrupted by user")
+	// errRunTimeout is the cancellation cause when -timeout expires.
+	errRunTimeout = errors.New("run timed out")
+)
+
+// scraperContext is cancelled on Ctrl-C or when -timeout expires, with
+// errInterrupted or errRunTimeout as the cause. An interrupted run stops
+// cleanly and leaves its checkpoints behind for the next one.
+func scraperContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		select {
+		case <-interrupts:
+			cancel(errInterrupted)
+		case <-ctx.Done():
+		}
+	}()
+
+	stop := func() {
+		signal.Stop(interrupts)
+		cancel(context.Canceled)
+	}
+
+	if *runTimeout > 0 {
+		timeoutCtx, cancelTimeout := context.WithTimeoutCause(ctx, *runTimeout, errRunTimeout)
+		return timeoutCtx, func() {
+			cancelTimeout()
+			stop()
+		}
+	}
+	return ctx, stop
+}
+
+// urlSource returns where the URLs to scrape come from, as chosen by the
+// -urls and -discover flags. Listings are fetched through sched.
+func urlSource(sched *politeScheduler) pipeline.Source[string] {
+	var sources []pipeline.Source[string]
+	if *urlsFile != "" {
+		sources = append(sources, fileSource(*urlsFile))
+	}
+	if *discoverURL != "" {
+		sources = append(sources, paginatedSource(sched, *discoverURL, *discoverItemURL, defaultRetryPolicy))
+	}
+	if len(sources) == 0 {
+		return generator(defaultURLs...)
+	}
+
+	return func(ctx context.Context, emit func(string) bool) error {
+		for _, src := range sources {
+			if err := src(ctx, emit); err != nil || ctx.Err() != nil {
+				return err
 			}
+		}
+		return nil
+	}
+}
 
-		}()
+// run pushes the URLs from src through the pipeline and returns once every
+// stage has finished.
+func (s *scraper) run(ctx context.Context, src pipeline.Source[string]) {
+	urlStream := pipeline.From("generator", src)
+
+	// Let fetches run up to a batch ahead of the inserts.
+	fetchOpts := []pipeline.StageOption{pipeline.WithWorkers(fetchWorkers), pipeline.WithBuffer(insertBatchSize)}
+	if *preserveFetchOrder {
+		fetchOpts = append(fetchOpts, pipeline.Ordered())
 	}
+	fetch := pipeline.Through(urlStream, "doHTTP",
+		s.checkpoints.fetchStage(doHTTP(defaultRetryPolicy, s.scheduler)),
+		append(fetchOpts, pipeline.WithTimeout(fetchStageTimeout))...)
+	stored := pipeline.ThroughBatch(fetch, "store", insertBatchSize, insertMaxLatency,
+		s.checkpoints.storeStage(tee(s.checkpoints, s.sinks...)), pipeline.WithTimeout(insertStageTimeout))
+	p := stored.To("logStored", logStored).WithMetrics(s.metrics)
+
+	s.errorLog.logErrors(s.deadLetters.tee(ctx, p.Run(ctx)))
+}
 
-	logErrorToFile(done, errChan)
+// newHTTPClient returns the client every scraper request goes through. A
+// positive rate throttles it to that many requests per second, with the
+// same transport the fan-out workers use.
+func newHTTPClient(rate float64) *http.Client {
+	if rate <= 0 {
+		return http.DefaultClient
+	}
+	limiter := ratelimit.NewBucket(rate, max(1, int(rate)), clock.Real())
+	return &http.Client{Transport: &ratelimit.Transport{Limiter: limiter}}
+}
 
-	pipeline := insertInDB(done, doHTTP(done, urlStream), db)
+// serveMetrics serves m on addr under /metrics until the returned server
+// is shut down.
+func serveMetrics(addr string, m *pipeline.Metrics) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	srv := &http.Server{Addr: addr, Handler: mux}
 
-	for val := range pipeline {
-		if val.Err != nil {
-			errChan <- val.Err
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Println("unable to serve metrics ", err)
 		}
+	}()
+	log.Printf("serving metrics on http://%s/metrics\n", addr)
+
+	return srv
+}
+
+func WebScrapperPipelineDriver() {
+	s, err := openScraper(scraperDBPath)
+	if err != nil {
+		log.Fatal("unable to open scraper ", err)
+	}
+	defer s.Close()
+
+	ctx, cancel := scraperContext()
+	defer cancel()
+
+	if *metricsAddr != "" {
+		srv := serveMetrics(*metricsAddr, s.metrics)
+		defer srv.Shutdown(context.Background())
 	}
-	close(errChan)
-	wg.Wait()
 
+	fmt.Println("Progress before run:")
+	s.checkpoints.writeProgress(ctx, os.Stdout)
+	defer func() {
+		fmt.Println("Progress after run:")
+		s.checkpoints.writeProgress(context.Background(), os.Stdout)
+		fmt.Println("Stage summary:")
+		s.metrics.WriteSummary(os.Stdout)
+	}()
+
+	s.run(ctx, s.checkpoints.pending(urlSource(s.scheduler)))
+	if ctx.Err() != nil {
+		log.Println("run stopped early: ", context.Cause(ctx))
+	}
 }
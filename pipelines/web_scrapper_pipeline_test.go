@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/VarthanV/go-concurrency-exercises/ratelimit"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB opens a fresh database in a temporary directory.
+func openTestDB(tb testing.TB) *gorm.DB {
+	tb.Helper()
+	db, err := openDB(filepath.Join(tb.TempDir(), "test.db"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	db.Logger = logger.Discard
+	tb.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// quietLog silences the log package for the rest of the test.
+func quietLog(tb testing.TB) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	tb.Cleanup(func() { log.SetOutput(out) })
+}
+
+func todoResult(id int, title string) *fetchResult {
+	return &fetchResult{
+		URL:      fmt.Sprintf("https://example.com/todos/%d", id),
+		Todo:     &Todo{ID: id, UserID: 1, Title: title},
+		Attempts: 1,
+	}
+}
+
+func TestInsertSavepointFallback(t *testing.T) {
+	quietLog(t)
+	db := openTestDB(t)
+
+	// Make one row fail on its own, which fails the batch insert as well.
+	err := db.Exec(`CREATE TRIGGER reject_bad BEFORE INSERT ON todos
+		WHEN NEW.title = 'bad' BEGIN SELECT RAISE(ABORT, 'bad todo'); END`).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch := []*fetchResult{
+		todoResult(1, "first"),
+		todoResult(2, "bad"),
+		{URL: "https://example.com/todos/null"},
+		todoResult(3, "third"),
+	}
+	_, errs := insertInDB(db)(context.Background(), batch)
+
+	for i, err := range errs {
+		switch i {
+		case 1:
+			if err == nil {
+				t.Errorf("item %d: no error for the rejected row", i)
+			}
+		case 2:
+			var de *DecodeError
+			if !errors.As(err, &de) {
+				t.Errorf("item %d: error %v, want a *DecodeError for the missing todo", i, err)
+			}
+		default:
+			if err != nil {
+				t.Errorf("item %d: %v, want it stored despite its neighbour", i, err)
+			}
+		}
+	}
+
+	var ids []int
+	if err := db.Model(&Todo{}).Order("id").Pluck("id", &ids).Error; err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ids) != "[1 3]" {
+		t.Fatalf("stored ids %v, want [1 3]", ids)
+	}
+}
+
+func TestInsertDuplicatesIgnored(t *testing.T) {
+	quietLog(t)
+	db := openTestDB(t)
+	insert := insertInDB(db)
+
+	for range 2 {
+		_, errs := insert(context.Background(), []*fetchResult{todoResult(1, "a"), todoResult(2, "b")})
+		if err := errors.Join(errs...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var n int64
+	db.Model(&Todo{}).Count(&n)
+	if n != 2 {
+		t.Fatalf("%d rows after inserting the same batch twice, want 2", n)
+	}
+}
+
+func benchmarkInsert(b *testing.B, batchSize int) {
+	quietLog(b)
+	db := openTestDB(b)
+	insert := insertInDB(db)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i += batchSize {
+		batch := make([]*fetchResult, 0, batchSize)
+		for id := i; id < min(i+batchSize, b.N); id++ {
+			batch = append(batch, todoResult(id+1, "benchmark"))
+		}
+		if _, errs := insert(ctx, batch); errors.Join(errs...) != nil {
+			b.Fatal(errors.Join(errs...))
+		}
+	}
+}
+
+// BenchmarkInsertSingle and BenchmarkInsertBatched report the cost per
+// todo of committing each one alone versus insertBatchSize at a time.
+func BenchmarkInsertSingle(b *testing.B) {
+	benchmarkInsert(b, 1)
+}
+
+func BenchmarkInsertBatched(b *testing.B) {
+	benchmarkInsert(b, insertBatchSize)
+}
+
+// countingWaiter lets every request through and counts them.
+type countingWaiter struct{ n atomic.Int64 }
+
+func (w *countingWaiter) Wait(ctx context.Context) error {
+	w.n.Add(1)
+	return ctx.Err()
+}
+
+// TestScraperRequestsUseClient checks that robots.txt, discovery and fetch
+// requests all go through the client the scraper was given, so -rate
+// throttles every one of them.
+func TestScraperRequestsUseClient(t *testing.T) {
+	quietLog(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			http.NotFound(w, r)
+		case "/todos":
+			w.Header().Set("Content-Type", "application/json")
+			if r.URL.Query().Get("page") == "1" {
+				fmt.Fprint(w, `[{"id": 1}]`)
+			} else {
+				fmt.Fprint(w, `[]`)
+			}
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"id": 1, "userId": 1, "title": "a"}`)
+		}
+	}))
+	defer srv.Close()
+
+	waiter := &countingWaiter{}
+	client := &http.Client{Transport: &ratelimit.Transport{Limiter: waiter}}
+	sched := newPoliteScheduler(client, "scraper/1.0", 1, 0)
+
+	urls, err := emitted(t, context.Background(),
+		paginatedSource(sched, srv.URL+"/todos?page={page}", srv.URL+"/todos/{id}", retryPolicy{maxAttempts: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := doHTTP(retryPolicy{maxAttempts: 1}, sched)(context.Background(), urls[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two listing pages, robots.txt and the todo.
+	if n := waiter.n.Load(); n != 4 {
+		t.Fatalf("limiter saw %d requests, want 4", n)
+	}
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	if newHTTPClient(0) != http.DefaultClient {
+		t.Fatal("rate 0 throttles the client")
+	}
+	if _, ok := newHTTPClient(2).Transport.(*ratelimit.Transport); !ok {
+		t.Fatal("positive rate does not throttle the client")
+	}
+}
@@ -1,3 +1,7 @@
 module github.com/VarthanV/go-concurrency-exercises/queueing
 
 go 1.22.6
+
+require github.com/VarthanV/go-concurrency-exercises/clock v0.0.0
+
+replace github.com/VarthanV/go-concurrency-exercises/clock => ../clock
@@ -5,20 +5,22 @@ import (
 	"log"
 	"sync"
 	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
 )
 
-func work(id int, wg *sync.WaitGroup, inputStream <-chan int, outStream chan<- int) {
+func work(clk clock.Clock, id int, wg *sync.WaitGroup, inputStream <-chan int, outStream chan<- int) {
 	go func() {
 		defer wg.Done()
 		log.Println("Spawned worker id ", id)
 		for val := range inputStream {
-			time.Sleep(2 * time.Second) // simulate work
+			clk.Sleep(2 * time.Second) // simulate work
 			outStream <- val * 2
 		}
 	}()
 }
 
-func queueing() {
+func queueing(clk clock.Clock) {
 	var (
 		numWorkers = 2
 		numJobs    = 10
@@ -33,7 +35,7 @@ func queueing() {
 	for i := 0; i < numWorkers; i++ {
 		// Spawn workers
 		wg.Add(1)
-		work(i, &wg, jobStream, outStream)
+		work(clk, i, &wg, jobStream, outStream)
 	}
 
 	// Feed jobs
@@ -58,5 +60,5 @@ func queueing() {
 }
 
 func main() {
-	queueing()
+	queueing(clock.Real())
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestQueueingUsesClock(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		queueing(clk)
+	}()
+
+	// 10 jobs of 2s each over 2 workers is five rounds of work, and the
+	// run cannot finish before the clock has moved through all of them.
+	for round := 1; round <= 5; round++ {
+		clk.BlockUntil(2)
+		select {
+		case <-done:
+			t.Fatalf("queueing returned after %d of 5 rounds", round-1)
+		default:
+		}
+		clk.Advance(2 * time.Second)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("queueing did not return after all jobs were worked off")
+	}
+	if elapsed := clk.Since(epoch); elapsed != 10*time.Second {
+		t.Fatalf("queueing took %v of clock time, want 10s", elapsed)
+	}
+}
@@ -0,0 +1,117 @@
+// Package ratelimit throttles outgoing HTTP requests. Transport waits on
+// any limiter with a Wait method before sending a request, so the same
+// client-side throttling can sit in front of every http.Client in the
+// exercises; Bucket is a small token bucket to drive it with.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+// Waiter blocks until the caller may go ahead or ctx is done.
+type Waiter interface {
+	Wait(ctx context.Context) error
+}
+
+// Transport is an http.RoundTripper that waits on Limiter before sending
+// each request, so any http.Client can be throttled by setting it as the
+// client's Transport.
+type Transport struct {
+	Limiter Waiter
+	// Next defaults to http.DefaultTransport.
+	Next http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.Limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return next.RoundTrip(req)
+}
+
+var errInvalidBurst = errors.New("ratelimit: burst must be positive")
+
+// Bucket is a token bucket that refills lazily at rate tokens per second
+// up to burst. It starts full. Waiters queue up by taking their token
+// right away, possibly driving the bucket negative, and sleeping until the
+// deficit is earned back.
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+	clock  clock.Clock
+}
+
+func NewBucket(rate float64, burst int, clk clock.Clock) *Bucket {
+	return &Bucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: float64(burst),
+		last:   clk.Now(),
+		clock:  clk,
+	}
+}
+
+// advance adds the tokens earned since the last call. Must be called with
+// mu held.
+func (b *Bucket) advance(now time.Time) {
+	if now.After(b.last) {
+		elapsed := now.Sub(b.last).Seconds()
+		b.tokens = math.Min(float64(b.burst), b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+}
+
+// Wait takes a token, blocking until it has been earned. If ctx ends
+// first the token is given back and ctx.Err() returned.
+func (b *Bucket) Wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	if b.burst <= 0 {
+		b.mu.Unlock()
+		return errInvalidBurst
+	}
+	b.advance(b.clock.Now())
+	b.tokens--
+	deficit := -b.tokens
+	b.mu.Unlock()
+
+	if deficit <= 0 {
+		return nil
+	}
+
+	var wait <-chan time.Time
+	if b.rate > 0 {
+		timer := b.clock.NewTimer(time.Duration(deficit / b.rate * float64(time.Second)))
+		defer timer.Stop()
+		wait = timer.C()
+	}
+
+	select {
+	case <-wait:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.advance(b.clock.Now())
+		b.tokens = math.Min(float64(b.burst), b.tokens+1)
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,168 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestBucketWait(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	b := NewBucket(2, 2, clk)
+	ctx := context.Background()
+
+	// The burst goes through right away.
+	for i := range 2 {
+		if err := b.Wait(ctx); err != nil {
+			t.Fatalf("Wait %d = %v", i, err)
+		}
+	}
+
+	// The next two queue up half a second apart.
+	done := make(chan error, 2)
+	for i := range 2 {
+		go func() { done <- b.Wait(ctx) }()
+		clk.BlockUntil(i + 1)
+	}
+
+	clk.Advance(500 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("second queued Wait returned %v after 500ms, want 1s", err)
+	default:
+	}
+	clk.Advance(500 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBucketWaitCanceled(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	b := NewBucket(1, 1, clk)
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- b.Wait(ctx) }()
+	clk.BlockUntil(1)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait = %v, want context.Canceled", err)
+	}
+
+	// The canceled waiter gave its token back, so the next one is due
+	// after one token, not two.
+	go func() { done <- b.Wait(context.Background()) }()
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBucketZeroRate(t *testing.T) {
+	b := NewBucket(0, 1, clock.NewFake(epoch))
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait at rate 0 = %v, want it to block until the context ends", err)
+	}
+
+	if err := NewBucket(1, 0, clock.NewFake(epoch)).Wait(context.Background()); !errors.Is(err, errInvalidBurst) {
+		t.Fatalf("Wait with burst 0 = %v, want errInvalidBurst", err)
+	}
+}
+
+func countingServer(t *testing.T) (*httptest.Server, *atomic.Int64) {
+	var hits atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func TestTransport(t *testing.T) {
+	srv, hits := countingServer(t)
+	clk := clock.NewFake(epoch)
+	client := &http.Client{Transport: &Transport{Limiter: NewBucket(1, 1, clk)}}
+
+	get := func() error {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	if err := get(); err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- get() }()
+	clk.BlockUntil(1)
+	if n := hits.Load(); n != 1 {
+		t.Fatalf("server saw %d requests before the limiter allowed a second, want 1", n)
+	}
+
+	clk.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Fatalf("second request: %v", err)
+	}
+	if n := hits.Load(); n != 2 {
+		t.Fatalf("server saw %d requests, want 2", n)
+	}
+}
+
+func TestTransportCanceled(t *testing.T) {
+	srv, hits := countingServer(t)
+	clk := clock.NewFake(epoch)
+	b := NewBucket(1, 1, clk)
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &Transport{Limiter: b}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		done <- err
+	}()
+	clk.BlockUntil(1)
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Do = %v, want context.Canceled", err)
+	}
+	if n := hits.Load(); n != 0 {
+		t.Fatalf("server saw %d requests, want none", n)
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+// sample is the outcome of one request seen by an adaptiveLimiter.
+type sample struct {
+	rtt time.Duration
+	// inFlight is the number of requests in flight when this one started.
+	inFlight int
+	// dropped is set when the request failed, was rejected with 429 or
+	// otherwise signalled that the upstream is overloaded.
+	dropped bool
+}
+
+// limitAlgorithm decides the next concurrency limit from the current one
+// and a fresh sample.
+type limitAlgorithm interface {
+	update(limit float64, s sample) float64
+}
+
+// aimdAlgorithm grows the limit by increase for every healthy response
+// while the limit is actually being used, and multiplies it by backoff on a
+// drop or when latency exceeds targetLatency.
+type aimdAlgorithm struct {
+	increase float64
+	backoff  float64
+	// targetLatency of zero disables the latency check.
+	targetLatency time.Duration
+}
+
+func newAIMDAlgorithm(targetLatency time.Duration) *aimdAlgorithm {
+	return &aimdAlgorithm{increase: 1, backoff: 0.9, targetLatency: targetLatency}
+}
+
+func (a *aimdAlgorithm) update(limit float64, s sample) float64 {
+	if s.dropped || (a.targetLatency > 0 && s.rtt > a.targetLatency) {
+		return limit * a.backoff
+	}
+	// Only grow when we are close to the limit; otherwise a healthy but
+	// idle client would inflate it without ever testing it.
+	if float64(s.inFlight)*2 >= limit {
+		return limit + a.increase
+	}
+	return limit
+}
+
+// vegasAlgorithm estimates the upstream queue from how far the current
+// latency is above the best latency seen so far (TCP Vegas style). The
+// limit grows while the queue is shorter than alpha and shrinks once it is
+// longer than beta.
+type vegasAlgorithm struct {
+	alpha   float64
+	beta    float64
+	backoff float64
+	minRTT  time.Duration
+}
+
+func newVegasAlgorithm() *vegasAlgorithm {
+	return &vegasAlgorithm{alpha: 3, beta: 6, backoff: 0.9}
+}
+
+func (v *vegasAlgorithm) update(limit float64, s sample) float64 {
+	if s.dropped {
+		return limit * v.backoff
+	}
+	if v.minRTT == 0 || s.rtt < v.minRTT {
+		v.minRTT = s.rtt
+	}
+	if s.rtt <= 0 {
+		return limit
+	}
+
+	queue := limit * (1 - float64(v.minRTT)/float64(s.rtt))
+	switch {
+	case queue < v.alpha:
+		return limit + 1
+	case queue > v.beta:
+		return limit - 1
+	}
+	return limit
+}
+
+// gradientAlgorithm compares a short term latency sample with a long term
+// moving average. When latency rises the gradient drops below one and the
+// limit shrinks in proportion; a sqrt(limit) headroom lets it keep probing
+// for more capacity.
+type gradientAlgorithm struct {
+	// smoothing is the weight given to the newly computed limit.
+	smoothing float64
+	backoff   float64
+	longRTT   float64
+}
+
+func newGradientAlgorithm() *gradientAlgorithm {
+	return &gradientAlgorithm{smoothing: 0.2, backoff: 0.9}
+}
+
+func (g *gradientAlgorithm) update(limit float64, s sample) float64 {
+	if s.dropped {
+		return limit * g.backoff
+	}
+	rtt := float64(s.rtt)
+	if rtt <= 0 {
+		return limit
+	}
+	if g.longRTT == 0 {
+		g.longRTT = rtt
+	}
+	g.longRTT = g.longRTT*0.95 + rtt*0.05
+
+	gradient := math.Max(0.5, math.Min(1, g.longRTT/rtt))
+	newLimit := limit*gradient + math.Sqrt(limit)
+	return limit*(1-g.smoothing) + newLimit*g.smoothing
+}
+
+// adaptiveLimiter caps the number of concurrent requests and tunes the cap
+// from observed latency and errors, so that a worker pool backs off on its
+// own when the upstream slows down and speeds up again when it recovers.
+type adaptiveLimiter struct {
+	mu        sync.Mutex
+	algorithm limitAlgorithm
+	limit     float64
+	minLimit  float64
+	maxLimit  float64
+	inFlight  int
+	// slotFreed is closed and replaced whenever a request finishes or the
+	// limit grows, so that blocked callers can re-check.
+	slotFreed chan struct{}
+	clock     clock.Clock
+	quitChan  chan struct{}
+	isClosed  bool
+}
+
+func newAdaptiveLimiter(algorithm limitAlgorithm, initialLimit, minLimit, maxLimit int, clk clock.Clock) *adaptiveLimiter {
+	return &adaptiveLimiter{
+		algorithm: algorithm,
+		limit:     float64(initialLimit),
+		minLimit:  float64(minLimit),
+		maxLimit:  float64(maxLimit),
+		slotFreed: make(chan struct{}),
+		clock:     clk,
+		quitChan:  make(chan struct{}),
+	}
+}
+
+// Limit returns the current concurrency limit.
+func (a *adaptiveLimiter) Limit() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return int(a.limit)
+}
+
+// InFlight returns the number of requests currently holding a slot.
+func (a *adaptiveLimiter) InFlight() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.inFlight
+}
+
+// tryAcquire must be called with mu held.
+func (a *adaptiveLimiter) tryAcquire() *adaptiveToken {
+	if a.inFlight >= max(1, int(a.limit)) {
+		return nil
+	}
+	a.inFlight++
+	return &adaptiveToken{
+		limiter:  a,
+		start:    a.clock.Now(),
+		inFlight: a.inFlight,
+	}
+}
+
+// TryAcquire returns a token if a slot is free right now.
+func (a *adaptiveLimiter) TryAcquire() (*adaptiveToken, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.isClosed {
+		return nil, false
+	}
+	t := a.tryAcquire()
+	return t, t != nil
+}
+
+// Acquire blocks until a slot is free. The returned token must be released
+// with exactly one of Success, Dropped or Ignore.
+func (a *adaptiveLimiter) Acquire(ctx context.Context) (*adaptiveToken, error) {
+	for {
+		a.mu.Lock()
+		if a.isClosed {
+			a.mu.Unlock()
+			return nil, errLimiterClosed
+		}
+		if t := a.tryAcquire(); t != nil {
+			a.mu.Unlock()
+			return t, nil
+		}
+		slotFreed := a.slotFreed
+		a.mu.Unlock()
+
+		select {
+		case <-slotFreed:
+		case <-a.quitChan:
+			return nil, errLimiterClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (a *adaptiveLimiter) release(s *sample) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.inFlight--
+	if s != nil {
+		a.limit = math.Max(a.minLimit, math.Min(a.maxLimit, a.algorithm.update(a.limit, *s)))
+	}
+	close(a.slotFreed)
+	a.slotFreed = make(chan struct{})
+}
+
+// Close wakes up every blocked caller and makes further calls fail.
+// It is safe to call Close more than once.
+func (a *adaptiveLimiter) Close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.isClosed {
+		return
+	}
+	a.isClosed = true
+	close(a.quitChan)
+}
+
+// adaptiveToken is a slot held by one in-flight request.
+type adaptiveToken struct {
+	limiter  *adaptiveLimiter
+	start    time.Time
+	inFlight int
+	once     sync.Once
+}
+
+func (t *adaptiveToken) finish(s *sample) {
+	t.once.Do(func() {
+		t.limiter.release(s)
+	})
+}
+
+// Success releases the slot and feeds the request latency to the algorithm.
+func (t *adaptiveToken) Success() {
+	t.finish(&sample{rtt: t.limiter.clock.Since(t.start), inFlight: t.inFlight})
+}
+
+// Dropped releases the slot and tells the algorithm to back off.
+func (t *adaptiveToken) Dropped() {
+	t.finish(&sample{rtt: t.limiter.clock.Since(t.start), inFlight: t.inFlight, dropped: true})
+}
+
+// Ignore releases the slot without affecting the limit, e.g. when the
+// request was cancelled by the caller.
+func (t *adaptiveToken) Ignore() {
+	t.finish(nil)
+}
+
+// Done classifies an HTTP outcome: transport errors, 429 and 5xx count as
+// drops, everything else as success.
+func (t *adaptiveToken) Done(resp *http.Response, err error) {
+	switch {
+	case err != nil:
+		t.Dropped()
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		t.Dropped()
+	default:
+		t.Success()
+	}
+}
+
+// adaptiveTransport is an http.RoundTripper that holds an adaptiveLimiter
+// slot for the duration of every request.
+type adaptiveTransport struct {
+	limiter *adaptiveLimiter
+	// next defaults to http.DefaultTransport.
+	next http.RoundTripper
+}
+
+func (t *adaptiveTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.limiter.Acquire(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	resp, err := next.RoundTrip(req)
+	if err != nil && req.Context().Err() != nil {
+		token.Ignore()
+		return nil, err
+	}
+	token.Done(resp, err)
+	return resp, err
+}
+
+// adaptiveLimitDriver runs a worker pool, once per algorithm, against an
+// upstream that serves capacity requests at a time and queues the rest,
+// rejecting with 429 once its queue is full. The workers share one
+// adaptiveTransport, whose limit should settle near the upstream's
+// capacity instead of the pool size, keeping latency and rejections down.
+func adaptiveLimitDriver() {
+	const (
+		workers  = 16
+		requests = 300
+		capacity = 4
+		maxQueue = 8
+	)
+
+	var (
+		inFlight atomic.Int64
+		slots    = make(chan struct{}, capacity)
+	)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer inFlight.Add(-1)
+		if inFlight.Add(1) > capacity+maxQueue {
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+
+		slots <- struct{}{}
+		defer func() { <-slots }()
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer upstream.Close()
+
+	algorithms := []struct {
+		name      string
+		algorithm limitAlgorithm
+	}{
+		{"aimd", newAIMDAlgorithm(25 * time.Millisecond)},
+		{"vegas", newVegasAlgorithm()},
+		{"gradient", newGradientAlgorithm()},
+	}
+
+	for _, a := range algorithms {
+		limiter := newAdaptiveLimiter(a.algorithm, workers, 1, workers, clock.Real())
+		client := &http.Client{Transport: &adaptiveTransport{limiter: limiter}}
+
+		jobs := make(chan struct{})
+		go func() {
+			defer close(jobs)
+			for i := 0; i < requests; i++ {
+				jobs <- struct{}{}
+			}
+		}()
+
+		var (
+			wg       sync.WaitGroup
+			rejected atomic.Int64
+			latency  atomic.Int64
+		)
+		start := time.Now()
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range jobs {
+					sent := time.Now()
+					resp, err := client.Get(upstream.URL)
+					if err != nil {
+						log.Println("error in doing request ", err)
+						continue
+					}
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+					latency.Add(int64(time.Since(sent)))
+					if resp.StatusCode == http.StatusTooManyRequests {
+						rejected.Add(1)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		limiter.Close()
+
+		fmt.Printf("%-8s %d requests with %d workers in %s: mean latency %s, %d rejected, final limit %d\n",
+			a.name, requests, workers, time.Since(start).Round(time.Millisecond),
+			(time.Duration(latency.Load()) / requests).Round(time.Millisecond), rejected.Load(), limiter.Limit())
+	}
+}
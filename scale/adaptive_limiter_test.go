@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+func TestAIMDUpdate(t *testing.T) {
+	a := newAIMDAlgorithm(100 * time.Millisecond)
+
+	tests := []struct {
+		name  string
+		limit float64
+		s     sample
+		want  float64
+	}{
+		{"healthy and busy grows", 10, sample{rtt: 50 * time.Millisecond, inFlight: 5}, 11},
+		{"healthy but idle holds", 10, sample{rtt: 50 * time.Millisecond, inFlight: 4}, 10},
+		{"drop backs off", 10, sample{rtt: 50 * time.Millisecond, inFlight: 10, dropped: true}, 9},
+		{"slow backs off", 10, sample{rtt: 150 * time.Millisecond, inFlight: 10}, 9},
+	}
+	for _, tt := range tests {
+		if got := a.update(tt.limit, tt.s); got != tt.want {
+			t.Errorf("%s: update(%v) = %v, want %v", tt.name, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestVegasUpdate(t *testing.T) {
+	v := newVegasAlgorithm()
+
+	steps := []struct {
+		name  string
+		limit float64
+		s     sample
+		want  float64
+	}{
+		// The first sample sets minRTT, so there is no queue yet.
+		{"no queue grows", 10, sample{rtt: 10 * time.Millisecond}, 11},
+		// queue = 10 * (1 - 10/20) = 5, between alpha and beta.
+		{"short queue holds", 10, sample{rtt: 20 * time.Millisecond}, 10},
+		// queue = 10 * (1 - 10/40) = 7.5, above beta.
+		{"long queue shrinks", 10, sample{rtt: 40 * time.Millisecond}, 9},
+		{"drop backs off", 10, sample{rtt: 10 * time.Millisecond, dropped: true}, 9},
+		// A faster sample lowers minRTT: queue = 10 * (1 - 5/10) = 5.
+		{"new minimum", 10, sample{rtt: 5 * time.Millisecond}, 11},
+		{"against new minimum", 10, sample{rtt: 10 * time.Millisecond}, 10},
+	}
+	for _, tt := range steps {
+		if got := v.update(tt.limit, tt.s); got != tt.want {
+			t.Errorf("%s: update(%v) = %v, want %v", tt.name, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestGradientUpdate(t *testing.T) {
+	g := newGradientAlgorithm()
+
+	// At steady latency the gradient is 1, and the limit probes upwards
+	// by smoothing * sqrt(limit).
+	if got, want := g.update(16, sample{rtt: 10 * time.Millisecond}), 16+0.2*4; math.Abs(got-want) > 1e-9 {
+		t.Fatalf("steady update(16) = %v, want %v", got, want)
+	}
+
+	// Latency doubling pulls the gradient towards 0.5 and the limit down.
+	if got := g.update(16, sample{rtt: 20 * time.Millisecond}); got >= 16 {
+		t.Fatalf("update(16) with doubled latency = %v, want below 16", got)
+	}
+
+	if got := g.update(16, sample{rtt: 10 * time.Millisecond, dropped: true}); math.Abs(got-16*0.9) > 1e-9 {
+		t.Fatalf("update(16) on a drop = %v, want %v", got, 16*0.9)
+	}
+}
+
+// recordingAlgorithm keeps the samples it sees and returns a fixed limit.
+type recordingAlgorithm struct {
+	samples []sample
+	next    float64
+}
+
+func (r *recordingAlgorithm) update(limit float64, s sample) float64 {
+	r.samples = append(r.samples, s)
+	return r.next
+}
+
+func TestAdaptiveLimiterSamples(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	alg := &recordingAlgorithm{next: 100}
+	a := newAdaptiveLimiter(alg, 2, 1, 3, clk)
+	defer a.Close()
+
+	first, _ := a.TryAcquire()
+	second, _ := a.TryAcquire()
+	if _, ok := a.TryAcquire(); ok {
+		t.Fatal("TryAcquire past the limit succeeded")
+	}
+
+	clk.Advance(30 * time.Millisecond)
+	second.Success()
+	// A token is released once, however often it is finished.
+	second.Dropped()
+	clk.Advance(20 * time.Millisecond)
+	first.Dropped()
+
+	want := []sample{
+		{rtt: 30 * time.Millisecond, inFlight: 2},
+		{rtt: 50 * time.Millisecond, inFlight: 1, dropped: true},
+	}
+	if len(alg.samples) != len(want) {
+		t.Fatalf("%d samples, want %d", len(alg.samples), len(want))
+	}
+	for i := range want {
+		if alg.samples[i] != want[i] {
+			t.Errorf("sample %d = %+v, want %+v", i, alg.samples[i], want[i])
+		}
+	}
+
+	// The algorithm asked for 100, but the limit is capped at maxLimit.
+	if got := a.Limit(); got != 3 {
+		t.Fatalf("Limit = %d, want maxLimit 3", got)
+	}
+	if got := a.InFlight(); got != 0 {
+		t.Fatalf("InFlight = %d, want 0", got)
+	}
+
+	// Ignore frees the slot without a sample.
+	tok, _ := a.TryAcquire()
+	tok.Ignore()
+	if len(alg.samples) != 2 {
+		t.Fatalf("Ignore fed a sample to the algorithm")
+	}
+}
+
+func TestAdaptiveLimiterAcquire(t *testing.T) {
+	a := newAdaptiveLimiter(newAIMDAlgorithm(0), 1, 1, 1, clock.NewFake(epoch))
+	defer a.Close()
+
+	held, err := a.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		tok, err := a.Acquire(context.Background())
+		if err == nil {
+			tok.Ignore()
+		}
+		acquired <- err
+	}()
+	select {
+	case err := <-acquired:
+		t.Fatalf("Acquire returned %v while the only slot was held", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	held.Success()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("Acquire = %v after the slot was released", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Acquire still blocked after the slot was released")
+	}
+}
+
+func TestAdaptiveLimiterAcquireClose(t *testing.T) {
+	a := newAdaptiveLimiter(newAIMDAlgorithm(0), 1, 1, 1, clock.NewFake(epoch))
+	if _, err := a.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		_, err := a.Acquire(context.Background())
+		acquired <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	a.Close()
+	select {
+	case err := <-acquired:
+		if !errors.Is(err, errLimiterClosed) {
+			t.Fatalf("Acquire = %v after Close, want errLimiterClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Acquire still blocked after Close")
+	}
+
+	if _, err := a.Acquire(context.Background()); !errors.Is(err, errLimiterClosed) {
+		t.Fatalf("Acquire on a closed limiter = %v, want errLimiterClosed", err)
+	}
+}
+
+func TestAdaptiveTransport(t *testing.T) {
+	var status atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer srv.Close()
+
+	alg := &recordingAlgorithm{next: 1}
+	a := newAdaptiveLimiter(alg, 1, 1, 1, clock.NewFake(epoch))
+	defer a.Close()
+	client := &http.Client{Transport: &adaptiveTransport{limiter: a}}
+
+	for _, code := range []int{http.StatusOK, http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+		status.Store(int64(code))
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	wantDropped := []bool{false, false, true, true}
+	if len(alg.samples) != len(wantDropped) {
+		t.Fatalf("%d samples, want %d", len(alg.samples), len(wantDropped))
+	}
+	for i, s := range alg.samples {
+		if s.dropped != wantDropped[i] {
+			t.Errorf("request %d: dropped = %v, want %v", i, s.dropped, wantDropped[i])
+		}
+	}
+	if a.InFlight() != 0 {
+		t.Fatalf("InFlight = %d after every response, want 0", a.InFlight())
+	}
+}
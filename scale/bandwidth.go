@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// limitedWriter caps the throughput of w in bytes per second. Each byte
+// costs one token, so the limiter's rate is the byte rate and its burst is
+// the largest chunk passed to w in one call. Several writers (and
+// limitedReaders) sharing one limiter share one budget.
+type limitedWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	limiter *rateLimiter
+}
+
+func newLimitedWriter(ctx context.Context, w io.Writer, limiter *rateLimiter) *limitedWriter {
+	return &limitedWriter{ctx: ctx, w: w, limiter: limiter}
+}
+
+func (lw *limitedWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		_, burst := lw.limiter.Limits()
+		chunk := min(len(p), burst)
+
+		if err := lw.limiter.WaitN(lw.ctx, chunk); err != nil {
+			return n, err
+		}
+
+		written, err := lw.w.Write(p[:chunk])
+		n += written
+		if err != nil {
+			return n, err
+		}
+		p = p[chunk:]
+	}
+	return n, nil
+}
+
+// limitedReader caps the throughput of r in bytes per second. A read is
+// never larger than the limiter's burst. It is paid for in full before r
+// is read, so no bytes are ever returned along with a limiter error, and
+// whatever a short read left unused is refunded afterwards.
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func newLimitedReader(ctx context.Context, r io.Reader, limiter *rateLimiter) *limitedReader {
+	return &limitedReader{ctx: ctx, r: r, limiter: limiter}
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return lr.r.Read(p)
+	}
+	if _, burst := lr.limiter.Limits(); len(p) > burst {
+		p = p[:burst]
+	}
+
+	if err := lr.limiter.WaitN(lr.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	n, err := lr.r.Read(p)
+	if n < len(p) {
+		lr.limiter.refund(len(p) - n)
+	}
+	return n, err
+}
+
+// bandwidthDriver copies a few streams through one shared 64 KiB/s budget,
+// each buffered with bufio like bufferedFileWriter in the bufferedio
+// exercise.
+func bandwidthDriver() {
+	const (
+		bytesPerSecond = 64 * 1024
+		streams        = 4
+		streamSize     = 32 * 1024
+	)
+
+	var wg sync.WaitGroup
+
+	limiter := (&rateLimiter{}).New(bytesPerSecond, 16*1024)
+	defer limiter.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+
+			bw := bufio.NewWriterSize(newLimitedWriter(ctx, io.Discard, limiter), 4096)
+			src := newLimitedReader(ctx, io.LimitReader(zeroReader{}, streamSize), limiter)
+
+			n, err := io.Copy(bw, src)
+			if err == nil {
+				err = bw.Flush()
+			}
+			if err != nil {
+				log.Println("error in copying stream ", id, err)
+				return
+			}
+			log.Printf("stream %d copied %d bytes\n", id, n)
+		}(i)
+	}
+	wg.Wait()
+
+	// Each byte is charged once on read and once on write.
+	fmt.Printf("copied %d bytes in %s\n", streams*streamSize, time.Since(start).Round(time.Millisecond))
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
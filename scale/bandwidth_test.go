@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+// chunkWriter records the size of every Write it gets.
+type chunkWriter struct {
+	mu     sync.Mutex
+	chunks []int
+	total  int
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.chunks = append(w.chunks, len(p))
+	w.total += len(p)
+	return len(p), nil
+}
+
+func (w *chunkWriter) written() (int, []int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.total, append([]int(nil), w.chunks...)
+}
+
+// All tests below use 100 bytes/s with a 10 byte burst. A fresh bucket
+// holds the full 10 bytes, and every further 10 take 100ms.
+func newBandwidthLimiter(clk clock.Clock) *rateLimiter {
+	return newRateLimiter(100, 10, clk)
+}
+
+func TestLimitedWriterRate(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	l := newBandwidthLimiter(clk)
+	defer l.Close()
+
+	out := &chunkWriter{}
+	w := newLimitedWriter(context.Background(), out, l)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := w.Write(make([]byte, 45))
+		done <- err
+	}()
+
+	// The first chunk goes out at once, then one per 100ms; the last 5
+	// bytes only need 50ms.
+	for i, step := range []time.Duration{100, 100, 100, 50} {
+		clk.BlockUntil(1)
+		if n, _ := out.written(); n != 10*(i+1) {
+			t.Fatalf("%d bytes written after %v, want %d", n, clk.Since(epoch), 10*(i+1))
+		}
+		clk.Advance(step * time.Millisecond)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	n, chunks := out.written()
+	if n != 45 {
+		t.Fatalf("%d bytes written, want 45", n)
+	}
+	want := []int{10, 10, 10, 10, 5}
+	if len(chunks) != len(want) {
+		t.Fatalf("chunks = %v, want %v", chunks, want)
+	}
+	for i := range want {
+		if chunks[i] != want[i] {
+			t.Fatalf("chunks = %v, want writes split at the burst: %v", chunks, want)
+		}
+	}
+}
+
+func TestLimitedReaderRate(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	l := newBandwidthLimiter(clk)
+	defer l.Close()
+
+	r := newLimitedReader(context.Background(), strings.NewReader(strings.Repeat("x", 25)), l)
+	buf := make([]byte, 64)
+
+	// A read is capped at the burst and paid for up front.
+	if n, err := r.Read(buf); n != 10 || err != nil {
+		t.Fatalf("Read = %d, %v; want 10, nil", n, err)
+	}
+
+	type result struct {
+		n   int
+		err error
+	}
+	read := func() <-chan result {
+		ch := make(chan result, 1)
+		go func() {
+			n, err := r.Read(buf)
+			ch <- result{n, err}
+		}()
+		return ch
+	}
+
+	got := read()
+	clk.BlockUntil(1)
+	clk.Advance(100 * time.Millisecond)
+	if res := <-got; res.n != 10 || res.err != nil {
+		t.Fatalf("Read = %d, %v; want 10, nil", res.n, res.err)
+	}
+
+	// Only 5 bytes are left; the other 5 tokens come back.
+	got = read()
+	clk.BlockUntil(1)
+	clk.Advance(100 * time.Millisecond)
+	if res := <-got; res.n != 5 || res.err != nil {
+		t.Fatalf("Read = %d, %v; want 5, nil", res.n, res.err)
+	}
+	if tokens := l.Tokens(); tokens != 5 {
+		t.Fatalf("Tokens = %v after a short read, want the unused 5 refunded", tokens)
+	}
+}
+
+func TestLimitedReaderCanceledReadsNothing(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	l := newBandwidthLimiter(clk)
+	defer l.Close()
+	l.ReserveN(10)
+
+	src := strings.NewReader("hello world")
+	ctx, cancel := context.WithCancel(context.Background())
+	r := newLimitedReader(ctx, src, l)
+
+	done := make(chan error, 1)
+	var n int
+	go func() {
+		var err error
+		n, err = r.Read(make([]byte, 5))
+		done <- err
+	}()
+	clk.BlockUntil(1)
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Read = %v, want context.Canceled", err)
+	}
+	if n != 0 || src.Len() != 11 {
+		t.Fatalf("Read returned %d bytes and consumed %d, want none of either", n, 11-src.Len())
+	}
+}
+
+func TestSharedBandwidth(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	l := newBandwidthLimiter(clk)
+	defer l.Close()
+
+	out := &chunkWriter{}
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := newLimitedWriter(context.Background(), out, l).Write(make([]byte, 30)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	// 60 bytes through one 100 B/s budget: the first 10 are free, the
+	// other 50 take 500ms however they are split between the streams.
+	for range 4 {
+		clk.BlockUntil(1)
+		clk.Advance(100 * time.Millisecond)
+	}
+	clk.BlockUntil(1)
+	if n, _ := out.written(); n > 50 {
+		t.Fatalf("%d bytes written after 400ms, want at most 50", n)
+	}
+	clk.Advance(100 * time.Millisecond)
+	wg.Wait()
+
+	if n, _ := out.written(); n != 60 {
+		t.Fatalf("%d bytes written, want 60", n)
+	}
+}
+
+func TestLimitedWriterBehindBufio(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	l := newBandwidthLimiter(clk)
+	defer l.Close()
+
+	out := &chunkWriter{}
+	bw := bufio.NewWriterSize(newLimitedWriter(context.Background(), out, l), 16)
+	data := bytes.Repeat([]byte("y"), 40)
+
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < len(data); i += 7 {
+			if _, err := bw.Write(data[i:min(i+7, len(data))]); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- bw.Flush()
+	}()
+
+	// Step the clock until the copy is through, however the writes line
+	// up with the steps.
+	for finished := false; !finished; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			finished = true
+		case <-time.After(time.Millisecond):
+			clk.Advance(10 * time.Millisecond)
+		}
+	}
+
+	n, chunks := out.written()
+	if n != 40 {
+		t.Fatalf("%d bytes written, want 40", n)
+	}
+	for _, c := range chunks {
+		if c > 10 {
+			t.Fatalf("chunks = %v, want none above the 10 byte burst", chunks)
+		}
+	}
+	// 40 bytes at 100 B/s with 10 up front cannot finish before 300ms.
+	if elapsed := clk.Since(epoch); elapsed < 300*time.Millisecond {
+		t.Fatalf("copy finished after %v, want at least 300ms", elapsed)
+	}
+}
+
+func TestLimitedCopy(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	l := newBandwidthLimiter(clk)
+	defer l.Close()
+
+	// Reading and writing are charged to the same budget.
+	ctx := context.Background()
+	out := &chunkWriter{}
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(newLimitedWriter(ctx, out, l), newLimitedReader(ctx, strings.NewReader(strings.Repeat("z", 20)), l))
+		done <- err
+	}()
+
+	for finished := false; !finished; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			finished = true
+		case <-time.After(time.Millisecond):
+			clk.Advance(10 * time.Millisecond)
+		}
+	}
+	if n, _ := out.written(); n != 20 {
+		t.Fatalf("%d bytes copied, want 20", n)
+	}
+	// 20 bytes read and 20 written is 40 tokens, 10 of them up front.
+	if elapsed := clk.Since(epoch); elapsed < 300*time.Millisecond {
+		t.Fatalf("copy finished after %v, want at least 300ms", elapsed)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+// fixedWindowLimiter admits at most limit requests per aligned window.
+// It is the cheapest algorithm but lets up to 2*limit requests through
+// around a window boundary.
+type fixedWindowLimiter struct {
+	limiterBase
+	limit       int
+	window      time.Duration
+	windowStart time.Time
+	count       int
+}
+
+func newFixedWindowLimiter(limit int, window time.Duration, clk clock.Clock) *fixedWindowLimiter {
+	return &fixedWindowLimiter{
+		limiterBase: newLimiterBase(clk),
+		limit:       limit,
+		window:      window,
+	}
+}
+
+func (f *fixedWindowLimiter) try(now time.Time) (time.Duration, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.isClosed {
+		return 0, errLimiterClosed
+	}
+
+	if start := now.Truncate(f.window); start.After(f.windowStart) {
+		f.windowStart = start
+		f.count = 0
+	}
+
+	if f.count < f.limit {
+		f.count++
+		return 0, nil
+	}
+	return f.windowStart.Add(f.window).Sub(now), nil
+}
+
+func (f *fixedWindowLimiter) Allow() bool {
+	wait, err := f.try(f.clock.Now())
+	return err == nil && wait <= 0
+}
+
+func (f *fixedWindowLimiter) Wait(ctx context.Context) error {
+	return waitFor(ctx, f.clock, f.quitChan, f.try)
+}
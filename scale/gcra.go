@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+// gcraLimiter implements the generic cell rate algorithm. Rather than
+// counting tokens it tracks the theoretical arrival time (tat) of the next
+// request: each admitted request pushes tat forward by one emission
+// interval, and a request is admitted as long as tat is no more than
+// burst intervals ahead of now. It behaves like a token bucket but only
+// stores a single timestamp.
+type gcraLimiter struct {
+	limiterBase
+	emissionInterval time.Duration
+	burstOffset      time.Duration
+	tat              time.Time
+}
+
+func newGCRALimiter(rate float64, burst int, clk clock.Clock) *gcraLimiter {
+	interval := time.Duration(float64(time.Second) / rate)
+	return &gcraLimiter{
+		limiterBase:      newLimiterBase(clk),
+		emissionInterval: interval,
+		burstOffset:      interval * time.Duration(burst),
+	}
+}
+
+func (g *gcraLimiter) try(now time.Time) (time.Duration, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.isClosed {
+		return 0, errLimiterClosed
+	}
+
+	tat := g.tat
+	if now.After(tat) {
+		tat = now
+	}
+	newTat := tat.Add(g.emissionInterval)
+
+	if allowAt := newTat.Add(-g.burstOffset); allowAt.After(now) {
+		return allowAt.Sub(now), nil
+	}
+	g.tat = newTat
+	return 0, nil
+}
+
+func (g *gcraLimiter) Allow() bool {
+	wait, err := g.try(g.clock.Now())
+	return err == nil && wait <= 0
+}
+
+func (g *gcraLimiter) Wait(ctx context.Context) error {
+	return waitFor(ctx, g.clock, g.quitChan, g.try)
+}
@@ -1,3 +1,13 @@
 module github.com/VarthanV/go-concurrency-exercises/scale
 
 go 1.22.6
+
+require (
+	github.com/VarthanV/go-concurrency-exercises/clock v0.0.0
+	github.com/VarthanV/go-concurrency-exercises/ratelimit v0.0.0
+)
+
+replace (
+	github.com/VarthanV/go-concurrency-exercises/clock => ../clock
+	github.com/VarthanV/go-concurrency-exercises/ratelimit => ../ratelimit
+)
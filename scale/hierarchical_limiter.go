@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+// limiterChain is a list of limiters a request has to pass all of, ordered
+// from the widest scope to the narrowest (global -> tenant -> endpoint).
+// Tokens are taken from every level at once or from none: while checking
+// a chain all of its locks are held, so a denial further down never leaves
+// an upper level charged. Chains sharing limiters must list them in the
+// same order, and a chain must not contain the same limiter twice.
+type limiterChain []*rateLimiter
+
+func (c limiterChain) lock() {
+	for _, l := range c {
+		l.mu.Lock()
+	}
+}
+
+func (c limiterChain) unlock() {
+	for i := len(c) - 1; i >= 0; i-- {
+		c[i].mu.Unlock()
+	}
+}
+
+// tryTakeN takes n tokens from every level if all of them have enough.
+// Otherwise nothing is taken and the longest wait across the levels is
+// returned.
+func (c limiterChain) tryTakeN(now time.Time, n int) (time.Duration, error) {
+	c.lock()
+	defer c.unlock()
+
+	var longest time.Duration
+	for _, l := range c {
+		wait, ok := l.waitLocked(now, n)
+		if !ok {
+			if l.isClosed {
+				return 0, errLimiterClosed
+			}
+			if n <= 0 {
+				return 0, errInvalidRequest
+			}
+			return 0, errExceedsBurst
+		}
+		longest = max(longest, wait)
+	}
+	if longest > 0 {
+		return longest, nil
+	}
+
+	for _, l := range c {
+		l.takeLocked(now, n, 0)
+	}
+	return 0, nil
+}
+
+func (c limiterChain) Allow() bool {
+	return c.AllowN(1)
+}
+
+func (c limiterChain) AllowN(n int) bool {
+	if len(c) == 0 {
+		return true
+	}
+	wait, err := c.tryTakeN(c[0].clock.Now(), n)
+	return err == nil && wait == 0
+}
+
+func (c limiterChain) Wait(ctx context.Context) error {
+	return c.WaitN(ctx, 1)
+}
+
+// WaitN blocks until every level can hand out n tokens at the same time.
+// Unlike rateLimiter.WaitN nothing is reserved while waiting, which keeps
+// the levels consistent at the cost of FIFO ordering between waiters.
+func (c limiterChain) WaitN(ctx context.Context, n int) error {
+	if len(c) == 0 {
+		return ctx.Err()
+	}
+	// Waking up when the widest level is closed covers the common case of
+	// shutting the whole hierarchy down; other levels are noticed on the
+	// next retry.
+	return waitFor(ctx, c[0].clock, c[0].quitChan, func(now time.Time) (time.Duration, error) {
+		return c.tryTakeN(now, n)
+	})
+}
+
+// tierConfig is the rate and burst of one level of a hierarchicalLimiter.
+// A zero burst leaves the level out.
+type tierConfig struct {
+	rate  float64
+	burst int
+}
+
+// hierarchicalLimiter charges each request to a global bucket, the
+// tenant's bucket and the tenant's bucket for the endpoint. To keep one
+// noisy tenant from draining the global bucket for everybody, each tenant
+// may only use maxShare of the global rate and burst.
+type hierarchicalLimiter struct {
+	global    *rateLimiter
+	shares    *keyedRateLimiter
+	tenants   *keyedRateLimiter
+	endpoints *keyedRateLimiter
+}
+
+// newHierarchicalLimiter builds the limiter. maxShare outside (0, 1)
+// disables the fair share cap; ttl and maxKeys bound the per-tenant and
+// per-endpoint buckets like in newKeyedRateLimiter.
+func newHierarchicalLimiter(global, tenant, endpoint tierConfig, maxShare float64, ttl time.Duration, maxKeys int, clk clock.Clock) *hierarchicalLimiter {
+	h := &hierarchicalLimiter{}
+
+	if global.burst > 0 {
+		h.global = newRateLimiter(global.rate, global.burst, clk)
+		if maxShare > 0 && maxShare < 1 {
+			shareBurst := max(1, int(float64(global.burst)*maxShare))
+			h.shares = newKeyedRateLimiter(global.rate*maxShare, shareBurst, ttl, maxKeys, clk)
+		}
+	}
+	if tenant.burst > 0 {
+		h.tenants = newKeyedRateLimiter(tenant.rate, tenant.burst, ttl, maxKeys, clk)
+	}
+	if endpoint.burst > 0 {
+		h.endpoints = newKeyedRateLimiter(endpoint.rate, endpoint.burst, ttl, maxKeys, clk)
+	}
+
+	return h
+}
+
+// chain returns the limiters a request from tenant to endpoint goes
+// through, widest first.
+func (h *hierarchicalLimiter) chain(tenant, endpoint string) (limiterChain, error) {
+	var c limiterChain
+
+	add := func(l *rateLimiter) error {
+		if l == nil {
+			return errLimiterClosed
+		}
+		c = append(c, l)
+		return nil
+	}
+
+	if h.global != nil {
+		if err := add(h.global); err != nil {
+			return nil, err
+		}
+	}
+	if h.shares != nil {
+		if err := add(h.shares.Get(tenant)); err != nil {
+			return nil, err
+		}
+	}
+	if h.tenants != nil {
+		if err := add(h.tenants.Get(tenant)); err != nil {
+			return nil, err
+		}
+	}
+	if h.endpoints != nil {
+		if err := add(h.endpoints.Get(tenant + "\x00" + endpoint)); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func (h *hierarchicalLimiter) Allow(tenant, endpoint string) bool {
+	c, err := h.chain(tenant, endpoint)
+	if err != nil {
+		return false
+	}
+	return c.Allow()
+}
+
+func (h *hierarchicalLimiter) Wait(ctx context.Context, tenant, endpoint string) error {
+	c, err := h.chain(tenant, endpoint)
+	if err != nil {
+		return err
+	}
+	return c.Wait(ctx)
+}
+
+// Close closes every level. It is safe to call Close more than once.
+func (h *hierarchicalLimiter) Close() {
+	if h.global != nil {
+		h.global.Close()
+	}
+	for _, k := range []*keyedRateLimiter{h.shares, h.tenants, h.endpoints} {
+		if k != nil {
+			k.Close()
+		}
+	}
+}
+
+// hierarchicalLimitDriver shows the fair share cap at work: a noisy tenant
+// hammering two endpoints only gets its share of the global bucket, which
+// leaves room for a quiet tenant arriving right after it.
+func hierarchicalLimitDriver() {
+	h := newHierarchicalLimiter(
+		tierConfig{rate: 10, burst: 10},
+		tierConfig{rate: 10, burst: 10},
+		tierConfig{rate: 4, burst: 4},
+		0.5, time.Minute, 1024, clock.Real())
+	defer h.Close()
+
+	try := func(tenant string, endpoints []string, requests int) {
+		allowed := make(map[string]int)
+		for i := 0; i < requests; i++ {
+			endpoint := endpoints[i%len(endpoints)]
+			if h.Allow(tenant, endpoint) {
+				allowed[endpoint]++
+			}
+		}
+		fmt.Printf("tenant %s: %d requests, allowed per endpoint %v\n", tenant, requests, allowed)
+	}
+
+	try("noisy", []string{"/search", "/export"}, 20)
+	try("quiet", []string{"/search"}, 5)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+// tokens returns what is left in l, which must not be nil.
+func tokens(t *testing.T, l *rateLimiter) float64 {
+	t.Helper()
+	if l == nil {
+		t.Fatal("no limiter at this level")
+	}
+	return l.Tokens()
+}
+
+func TestHierarchicalAllOrNothing(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	// Every bucket starts full, since each rate is at least its burst.
+	h := newHierarchicalLimiter(
+		tierConfig{rate: 10, burst: 10},
+		tierConfig{rate: 5, burst: 5},
+		tierConfig{rate: 2, burst: 2},
+		0, 0, 0, clk)
+	defer h.Close()
+
+	steps := []struct {
+		endpoint string
+		allowed  bool
+	}{
+		{"/a", true},
+		{"/a", true},
+		// The endpoint bucket is empty.
+		{"/a", false},
+		{"/b", true},
+		{"/b", true},
+		{"/c", true},
+		// The tenant bucket is empty, though /c has a token left.
+		{"/c", false},
+	}
+	for i, s := range steps {
+		if got := h.Allow("t1", s.endpoint); got != s.allowed {
+			t.Fatalf("step %d: Allow(t1, %s) = %v, want %v", i, s.endpoint, got, s.allowed)
+		}
+	}
+
+	// Exactly the five admitted requests were charged at every level.
+	if got := tokens(t, h.global); got != 5 {
+		t.Errorf("global tokens = %v, want 5", got)
+	}
+	if got := tokens(t, h.tenants.Get("t1")); got != 0 {
+		t.Errorf("tenant tokens = %v, want 0", got)
+	}
+	if got := tokens(t, h.endpoints.Get("t1\x00/c")); got != 1 {
+		t.Errorf("/c tokens = %v, want 1", got)
+	}
+}
+
+func TestHierarchicalDenialChargesNothingUpstream(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	h := newHierarchicalLimiter(
+		tierConfig{rate: 10, burst: 10},
+		tierConfig{rate: 10, burst: 10},
+		tierConfig{rate: 1, burst: 1},
+		0, 0, 0, clk)
+	defer h.Close()
+
+	if !h.Allow("t1", "/a") {
+		t.Fatal("first request denied")
+	}
+	for range 100 {
+		if h.Allow("t1", "/a") {
+			t.Fatal("request allowed past an empty endpoint bucket")
+		}
+	}
+
+	if got := tokens(t, h.global); got != 9 {
+		t.Errorf("global tokens = %v after 100 denials, want 9", got)
+	}
+	if got := tokens(t, h.tenants.Get("t1")); got != 9 {
+		t.Errorf("tenant tokens = %v after 100 denials, want 9", got)
+	}
+}
+
+func TestHierarchicalMaxShare(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	h := newHierarchicalLimiter(
+		tierConfig{rate: 10, burst: 10},
+		tierConfig{rate: 100, burst: 100},
+		tierConfig{},
+		0.4, 0, 0, clk)
+	defer h.Close()
+
+	count := func(tenant string) int {
+		n := 0
+		for range 20 {
+			if h.Allow(tenant, "/") {
+				n++
+			}
+		}
+		return n
+	}
+
+	// Each tenant gets at most 40% of the global burst.
+	for _, tenant := range []string{"noisy", "other"} {
+		if got := count(tenant); got != 4 {
+			t.Fatalf("%s: %d allowed, want its share of 4", tenant, got)
+		}
+	}
+	// A third tenant only gets what is left of the global bucket.
+	if got := count("third"); got != 2 {
+		t.Fatalf("third: %d allowed, want the remaining 2", got)
+	}
+	if got := tokens(t, h.global); got != 0 {
+		t.Fatalf("global tokens = %v, want 0", got)
+	}
+}
+
+func TestHierarchicalWait(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	h := newHierarchicalLimiter(
+		tierConfig{rate: 10, burst: 10},
+		tierConfig{rate: 1, burst: 1},
+		tierConfig{},
+		0, 0, 0, clk)
+
+	h.Allow("t1", "/")
+
+	// The tenant level earns its next token after 1s.
+	done := make(chan error, 1)
+	go func() { done <- h.Wait(context.Background(), "t1", "/") }()
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Fatalf("Wait = %v", err)
+	}
+
+	go func() { done <- h.Wait(context.Background(), "t1", "/") }()
+	clk.BlockUntil(1)
+	h.Close()
+	if err := <-done; !errors.Is(err, errLimiterClosed) {
+		t.Fatalf("Wait = %v after Close, want errLimiterClosed", err)
+	}
+	if h.Allow("t1", "/") {
+		t.Fatal("Allow succeeded on a closed limiter")
+	}
+}
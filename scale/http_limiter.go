@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// keyFunc picks the bucket a request is charged to.
+type keyFunc func(r *http.Request) string
+
+// keyByIP charges requests to the client's IP address.
+func keyByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// keyByHeader charges requests to the value of the given header, such as
+// an API key.
+func keyByHeader(name string) keyFunc {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// rateLimitHandler rejects requests over the limit with 429 Too Many
+// Requests. Every response carries X-RateLimit-* headers describing the
+// caller's bucket, and rejected ones a Retry-After header as well.
+type rateLimitHandler struct {
+	limiter *keyedRateLimiter
+	key     keyFunc
+	next    http.Handler
+}
+
+// newRateLimitHandler wraps next. If key is nil every request shares one
+// bucket.
+func newRateLimitHandler(limiter *keyedRateLimiter, key keyFunc, next http.Handler) http.Handler {
+	if key == nil {
+		key = func(*http.Request) string { return "" }
+	}
+	return &rateLimitHandler{
+		limiter: limiter,
+		key:     key,
+		next:    next,
+	}
+}
+
+func (h *rateLimitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l := h.limiter.Get(h.key(r))
+	if l == nil {
+		http.Error(w, errLimiterClosed.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	st := l.allowWithStatus()
+
+	header := w.Header()
+	header.Set("X-RateLimit-Limit", strconv.Itoa(st.limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(st.remaining))
+	header.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(st.reset)))
+
+	if !st.allowed {
+		header.Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(st.retryAfter))))
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+
+	h.next.ServeHTTP(w, r)
+}
+
+// rateLimitStatus is what the X-RateLimit-* headers report about a bucket
+// right after a request was charged to it.
+type rateLimitStatus struct {
+	allowed   bool
+	limit     int
+	remaining int
+	// reset is how long until the bucket is full again.
+	reset time.Duration
+	// retryAfter is how long until the next token, if allowed is false.
+	retryAfter time.Duration
+}
+
+// allowWithStatus is Allow, also returning the state of the bucket as of
+// the same instant, read under one lock so a concurrent SetRate/SetBurst
+// cannot mix old and new limits.
+func (r *rateLimiter) allowWithStatus() rateLimitStatus {
+	now := r.clock.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.takeLocked(now, 1, 0)
+	r.advance(now)
+	tokens := r.currentTokens
+	return rateLimitStatus{
+		allowed:    ok,
+		limit:      r.burstRate,
+		remaining:  max(0, int(math.Floor(tokens))),
+		reset:      r.durationFor(float64(r.burstRate) - tokens),
+		retryAfter: r.durationFor(1 - tokens),
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	if d >= infDuration {
+		return math.MaxInt32
+	}
+	return int(math.Ceil(d.Seconds()))
+}
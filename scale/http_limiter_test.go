@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+	"github.com/VarthanV/go-concurrency-exercises/ratelimit"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func TestRateLimitHandler(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	keyed := newKeyedRateLimiter(1, 2, 0, 0, clk)
+	defer keyed.Close()
+	h := newRateLimitHandler(keyed, keyByIP, okHandler)
+
+	tests := []struct {
+		advance    time.Duration
+		remoteAddr string
+		status     int
+		remaining  string
+		reset      string
+		retryAfter string
+	}{
+		// A new bucket starts with one second's worth of tokens.
+		{0, "10.0.0.1:1234", http.StatusOK, "0", "2", ""},
+		{0, "10.0.0.1:1234", http.StatusTooManyRequests, "0", "2", "1"},
+		// Another client has a bucket of its own.
+		{0, "10.0.0.2:1234", http.StatusOK, "0", "2", ""},
+		// Half a token is not enough, and Retry-After rounds up.
+		{500 * time.Millisecond, "10.0.0.1:1234", http.StatusTooManyRequests, "0", "2", "1"},
+		{500 * time.Millisecond, "10.0.0.1:1234", http.StatusOK, "0", "2", ""},
+		// The bucket refills up to the burst.
+		{5 * time.Second, "10.0.0.1:1234", http.StatusOK, "1", "1", ""},
+	}
+
+	for i, tt := range tests {
+		clk.Advance(tt.advance)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		header := rec.Header()
+		if rec.Code != tt.status {
+			t.Errorf("request %d: status %d, want %d", i, rec.Code, tt.status)
+		}
+		if got := header.Get("X-RateLimit-Limit"); got != "2" {
+			t.Errorf("request %d: X-RateLimit-Limit %q, want 2", i, got)
+		}
+		if got := header.Get("X-RateLimit-Remaining"); got != tt.remaining {
+			t.Errorf("request %d: X-RateLimit-Remaining %q, want %q", i, got, tt.remaining)
+		}
+		if got := header.Get("X-RateLimit-Reset"); got != tt.reset {
+			t.Errorf("request %d: X-RateLimit-Reset %q, want %q", i, got, tt.reset)
+		}
+		if got := header.Get("Retry-After"); got != tt.retryAfter {
+			t.Errorf("request %d: Retry-After %q, want %q", i, got, tt.retryAfter)
+		}
+	}
+}
+
+func TestRateLimitHandlerClosed(t *testing.T) {
+	keyed := newKeyedRateLimiter(1, 1, 0, 0, clock.NewFake(epoch))
+	keyed.Close()
+	h := newRateLimitHandler(keyed, nil, okHandler)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status %d from a closed limiter, want 503", rec.Code)
+	}
+}
+
+// countingServer counts the requests that reach it.
+func countingServer(t *testing.T) (*httptest.Server, *atomic.Int64) {
+	var hits atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func TestRateLimitedTransport(t *testing.T) {
+	srv, hits := countingServer(t)
+	clk := clock.NewFake(epoch)
+	l := newRateLimiter(1, 1, clk)
+	defer l.Close()
+	client := &http.Client{Transport: &ratelimit.Transport{Limiter: l}}
+
+	get := func() error {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	if err := get(); err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- get() }()
+	clk.BlockUntil(1)
+	if n := hits.Load(); n != 1 {
+		t.Fatalf("server saw %d requests before the limiter allowed a second, want 1", n)
+	}
+
+	clk.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Fatalf("second request: %v", err)
+	}
+	if n := hits.Load(); n != 2 {
+		t.Fatalf("server saw %d requests, want 2", n)
+	}
+}
+
+func TestRateLimitedTransportCanceled(t *testing.T) {
+	srv, hits := countingServer(t)
+	clk := clock.NewFake(epoch)
+	l := newRateLimiter(1, 1, clk)
+	defer l.Close()
+	l.Allow()
+	client := &http.Client{Transport: &ratelimit.Transport{Limiter: l}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		done <- err
+	}()
+	clk.BlockUntil(1)
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Do = %v, want context.Canceled", err)
+	}
+	if n := hits.Load(); n != 0 {
+		t.Fatalf("server saw %d requests, want none", n)
+	}
+}
+
+func TestRateLimitHandlerOverHTTP(t *testing.T) {
+	keyed := newKeyedRateLimiter(1, 1, 0, 0, clock.NewFake(epoch))
+	defer keyed.Close()
+	srv := httptest.NewServer(newRateLimitHandler(keyed, keyByHeader("X-API-Key"), okHandler))
+	defer srv.Close()
+
+	get := func(key string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		req.Header.Set("X-API-Key", key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := get("a"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("first request with key a: %s", resp.Status)
+	}
+	resp := get("a")
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "1" {
+		t.Fatalf("second request with key a: %s, Retry-After %q; want 429 and 1",
+			resp.Status, resp.Header.Get("Retry-After"))
+	}
+	if resp := get("b"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("first request with key b: %s", resp.Status)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+const defaultLimiterShards = 32
+
+// keyedRateLimiter hands out one token bucket per key (client IP, API key,
+// ...) with a shared rate and burst. Keys are spread over shards so that
+// callers for different keys rarely contend on the same mutex. Keys that
+// have not been seen for ttl are evicted by a janitor goroutine, and each
+// shard holds at most maxKeys/len(shards) keys, evicting the least
+// recently seen one when full, so no more than maxKeys are ever held.
+type keyedRateLimiter struct {
+	rate        float64
+	burstRate   int
+	ttl         time.Duration
+	maxPerShard int
+	shards      []*limiterShard
+	clock       clock.Clock
+	quitChan    chan struct{}
+	closeOnce   sync.Once
+	janitorDone chan struct{}
+}
+
+type limiterShard struct {
+	mu       sync.Mutex
+	limiters map[string]*list.Element
+	// recent orders the shard's *keyedEntry values from the most to the
+	// least recently seen, so evicting never has to scan the whole shard.
+	recent   list.List
+	isClosed bool
+}
+
+type keyedEntry struct {
+	key      string
+	limiter  *rateLimiter
+	lastSeen time.Time
+}
+
+// newKeyedRateLimiter creates a keyed limiter. A ttl of zero disables idle
+// eviction and a maxKeys of zero leaves the number of keys unbounded.
+func newKeyedRateLimiter(rate float64, burstRate int, ttl time.Duration, maxKeys int, clk clock.Clock) *keyedRateLimiter {
+	shards := defaultLimiterShards
+	if maxKeys > 0 {
+		// Every shard holds at least one key, so with fewer keys than
+		// shards some shards would push the total past maxKeys.
+		shards = min(shards, maxKeys)
+	}
+
+	k := &keyedRateLimiter{
+		rate:        rate,
+		burstRate:   burstRate,
+		ttl:         ttl,
+		shards:      make([]*limiterShard, shards),
+		clock:       clk,
+		quitChan:    make(chan struct{}),
+		janitorDone: make(chan struct{}),
+	}
+
+	if maxKeys > 0 {
+		// Round down: the bound matters more than using all of maxKeys.
+		k.maxPerShard = maxKeys / shards
+	}
+
+	for i := range k.shards {
+		k.shards[i] = &limiterShard{limiters: make(map[string]*list.Element)}
+	}
+
+	if ttl > 0 {
+		go k.janitor(max(ttl/2, time.Millisecond))
+	} else {
+		close(k.janitorDone)
+	}
+
+	return k
+}
+
+func (k *keyedRateLimiter) shardFor(key string) *limiterShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return k.shards[h.Sum32()%uint32(len(k.shards))]
+}
+
+// Get returns the limiter for key, creating it on first use. It returns
+// nil once the keyed limiter is closed.
+func (k *keyedRateLimiter) Get(key string) *rateLimiter {
+	s := k.shardFor(key)
+	now := k.clock.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.isClosed {
+		return nil
+	}
+
+	if elem, ok := s.limiters[key]; ok {
+		e := elem.Value.(*keyedEntry)
+		e.lastSeen = now
+		s.recent.MoveToFront(elem)
+		return e.limiter
+	}
+
+	if k.maxPerShard > 0 && len(s.limiters) >= k.maxPerShard {
+		s.evictOldest()
+	}
+
+	e := &keyedEntry{
+		key:      key,
+		limiter:  newRateLimiter(k.rate, k.burstRate, k.clock),
+		lastSeen: now,
+	}
+	s.limiters[key] = s.recent.PushFront(e)
+	return e.limiter
+}
+
+// Allow reports whether a request for key may proceed right now.
+func (k *keyedRateLimiter) Allow(key string) bool {
+	l := k.Get(key)
+	if l == nil {
+		return false
+	}
+	return l.Allow()
+}
+
+// Wait blocks until the bucket for key has a token or ctx is done.
+func (k *keyedRateLimiter) Wait(ctx context.Context, key string) error {
+	l := k.Get(key)
+	if l == nil {
+		return errLimiterClosed
+	}
+	return l.Wait(ctx)
+}
+
+// Len returns the number of keys currently tracked.
+func (k *keyedRateLimiter) Len() int {
+	n := 0
+	for _, s := range k.shards {
+		s.mu.Lock()
+		n += len(s.limiters)
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// evictOldest drops the least recently seen key. Must be called with mu
+// held.
+func (s *limiterShard) evictOldest() {
+	if elem := s.recent.Back(); elem != nil {
+		s.remove(elem)
+	}
+}
+
+// remove must be called with mu held.
+func (s *limiterShard) remove(elem *list.Element) {
+	e := s.recent.Remove(elem).(*keyedEntry)
+	delete(s.limiters, e.key)
+}
+
+// evictIdle drops every key not seen since cutoff, walking from the least
+// recently seen end until it reaches a key that is still fresh. Evicted
+// limiters are not closed: they hold no goroutine, and a caller still
+// waiting on one should not be failed just because its key went quiet.
+func (s *limiterShard) evictIdle(cutoff time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for elem := s.recent.Back(); elem != nil; elem = s.recent.Back() {
+		if !elem.Value.(*keyedEntry).lastSeen.Before(cutoff) {
+			return
+		}
+		s.remove(elem)
+	}
+}
+
+func (k *keyedRateLimiter) janitor(every time.Duration) {
+	defer close(k.janitorDone)
+
+	// A timer re-armed after each sweep rather than a ticker: sweeps never
+	// pile up, and a fake clock sees the janitor waiting again only once
+	// the sweep is done.
+	timer := k.clock.NewTimer(every)
+	defer timer.Stop()
+
+	for {
+		select {
+		case now := <-timer.C():
+			cutoff := now.Add(-k.ttl)
+			for _, s := range k.shards {
+				s.evictIdle(cutoff)
+			}
+			timer.Reset(every)
+		case <-k.quitChan:
+			return
+		}
+	}
+}
+
+// Close stops the janitor and closes every tracked limiter, waking up
+// their waiters. It is safe to call Close more than once.
+func (k *keyedRateLimiter) Close() {
+	k.closeOnce.Do(func() {
+		close(k.quitChan)
+		<-k.janitorDone
+
+		for _, s := range k.shards {
+			s.mu.Lock()
+			s.isClosed = true
+			for elem := s.recent.Front(); elem != nil; elem = elem.Next() {
+				elem.Value.(*keyedEntry).limiter.Close()
+			}
+			s.limiters = make(map[string]*list.Element)
+			s.recent.Init()
+			s.mu.Unlock()
+		}
+	})
+}
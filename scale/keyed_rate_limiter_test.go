@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+// eventually polls cond until it holds or a second has passed. Work a
+// fake clock hands to another goroutine still runs in real time.
+func eventually(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestKeyedRateLimiterTTL(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	k := newKeyedRateLimiter(1, 1, time.Minute, 0, clk)
+	defer k.Close()
+
+	k.Get("idle")
+	k.Get("busy")
+
+	// The janitor sweeps every ttl/2 and re-arms its timer once a sweep is
+	// done, so BlockUntil returning means the sweep has finished.
+	sweep := func() {
+		clk.BlockUntil(1)
+		clk.Advance(30 * time.Second)
+		clk.BlockUntil(1)
+	}
+
+	for range 2 {
+		sweep()
+		k.Get("busy")
+	}
+
+	// idle was last seen one ttl ago, which is not yet past the cutoff.
+	if n := k.Len(); n != 2 {
+		t.Fatalf("Len = %d after exactly one ttl, want 2", n)
+	}
+
+	sweep()
+	if n := k.Len(); n != 1 {
+		t.Fatalf("Len = %d after idle's ttl ran out, want 1", n)
+	}
+
+	// busy is still tracked: Get hands back the same bucket, not a fresh one.
+	l := k.Get("busy")
+	l.Allow()
+	if k.Get("busy").Allow() {
+		t.Fatal("busy got a fresh bucket, want the one it already drained")
+	}
+}
+
+func TestKeyedRateLimiterMaxKeys(t *testing.T) {
+	for _, maxKeys := range []int{1, 5, 31, 32, 33, 100} {
+		t.Run(fmt.Sprint(maxKeys), func(t *testing.T) {
+			clk := clock.NewFake(epoch)
+			k := newKeyedRateLimiter(1, 1, 0, maxKeys, clk)
+			defer k.Close()
+
+			for i := range 10 * maxKeys {
+				k.Get(fmt.Sprint("key-", i))
+				clk.Advance(time.Millisecond)
+				if n := k.Len(); n > maxKeys {
+					t.Fatalf("Len = %d after %d keys, want at most %d", n, i+1, maxKeys)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyedRateLimiterEvictsLeastRecentlySeen(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	k := newKeyedRateLimiter(1, 1, 0, 2, clk)
+	defer k.Close()
+
+	// Find two keys sharing a shard and one in the other shard.
+	var same []string
+	var other string
+	for i := 0; len(same) < 2 || other == ""; i++ {
+		key := fmt.Sprint("key-", i)
+		switch {
+		case k.shardFor(key) == k.shards[0]:
+			same = append(same, key)
+		case other == "":
+			other = key
+		}
+	}
+
+	o := k.Get(other)
+	k.Get(same[0])
+	clk.Advance(time.Second)
+	k.Get(same[1])
+
+	if n := k.Len(); n != 2 {
+		t.Fatalf("Len = %d, want 2", n)
+	}
+	if k.Get(other) != o {
+		t.Fatal("key in the other shard was evicted, want only the full shard to evict")
+	}
+}
+
+// TestKeyedRateLimiterConcurrent hammers a small limiter from many
+// goroutines so that Get, eviction by size and by age, and Close all race
+// with each other. It is meant to run under -race.
+func TestKeyedRateLimiterConcurrent(t *testing.T) {
+	const (
+		workers = 16
+		calls   = 2000
+		maxKeys = 64
+	)
+
+	clk := clock.NewFake(epoch)
+	k := newKeyedRateLimiter(100, 10, time.Second, maxKeys, clk)
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range calls {
+				// Mostly a hot set of keys, with a long tail that keeps the
+				// shards full.
+				key := fmt.Sprint("hot-", i%8)
+				if i%3 == 0 {
+					key = fmt.Sprint("cold-", w, "-", i)
+				}
+				if l := k.Get(key); l != nil {
+					l.Allow()
+				}
+				if n := k.Len(); n > maxKeys {
+					t.Errorf("Len = %d, want at most %d", n, maxKeys)
+					return
+				}
+			}
+		}()
+	}
+
+	// Let the janitor sweep while the workers run.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 20 {
+			clk.Advance(600 * time.Millisecond)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	wg.Wait()
+	<-done
+	k.Close()
+
+	if n := k.Len(); n != 0 {
+		t.Fatalf("Len = %d after Close, want 0", n)
+	}
+	if k.Get("hot-0") != nil {
+		t.Fatal("Get after Close returned a limiter")
+	}
+}
+
+func BenchmarkKeyedRateLimiterGetFull(b *testing.B) {
+	const maxKeys = 1 << 14
+	k := newKeyedRateLimiter(1, 1, 0, maxKeys, clock.Real())
+	defer k.Close()
+
+	keys := make([]string, 4*maxKeys)
+	for i := range keys {
+		keys[i] = fmt.Sprint("key-", i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k.Get(keys[i%len(keys)])
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+var errQueueFull = errors.New("leaky bucket queue is full")
+
+// leakyBucketLimiter shapes traffic instead of rejecting it: every caller
+// of Wait is given a slot in a queue that drains at a constant rate, so
+// requests leave evenly spaced no matter how bursty they arrive. Only when
+// more than capacity requests are queued is a caller turned away.
+type leakyBucketLimiter struct {
+	limiterBase
+	interval time.Duration
+	capacity int
+	// last is the time the most recently queued request is released.
+	last time.Time
+}
+
+func newLeakyBucketLimiter(rate float64, capacity int, clk clock.Clock) *leakyBucketLimiter {
+	return &leakyBucketLimiter{
+		limiterBase: newLimiterBase(clk),
+		interval:    time.Duration(float64(time.Second) / rate),
+		capacity:    capacity,
+	}
+}
+
+// enqueue books the next free slot and returns when it is released.
+func (l *leakyBucketLimiter) enqueue(now time.Time) (time.Time, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.isClosed {
+		return time.Time{}, errLimiterClosed
+	}
+
+	next := l.last.Add(l.interval)
+	if next.Before(now) {
+		next = now
+	}
+	if queued := next.Sub(now) / l.interval; int(queued) >= l.capacity {
+		return time.Time{}, errQueueFull
+	}
+	l.last = next
+	return next, nil
+}
+
+// dequeue gives a slot back if nobody has queued behind it yet.
+func (l *leakyBucketLimiter) dequeue(slot time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.last.Equal(slot) {
+		l.last = slot.Add(-l.interval)
+	}
+}
+
+// Allow only admits a request that would not have to queue at all.
+func (l *leakyBucketLimiter) Allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	if l.isClosed || l.last.Add(l.interval).After(now) {
+		return false
+	}
+	l.last = now
+	return true
+}
+
+// Wait queues the caller and blocks until its slot is released. It fails
+// with errQueueFull right away if the queue is at capacity.
+func (l *leakyBucketLimiter) Wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	now := l.clock.Now()
+	slot, err := l.enqueue(now)
+	if err != nil {
+		return err
+	}
+	if !slot.After(now) {
+		return nil
+	}
+
+	timer := l.clock.NewTimer(slot.Sub(now))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+		return nil
+	case <-l.quitChan:
+		return errLimiterClosed
+	case <-ctx.Done():
+		l.dequeue(slot)
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+// Limiter is implemented by every rate-limiting algorithm in this package
+// so that callers can pick one per endpoint without caring how it counts.
+type Limiter interface {
+	// Allow reports whether a request may proceed right now, consuming
+	// capacity if it does.
+	Allow() bool
+	// Wait blocks until a request may proceed, ctx is done or the limiter
+	// is closed.
+	Wait(ctx context.Context) error
+	// Close releases blocked waiters with errLimiterClosed.
+	Close()
+}
+
+var (
+	_ Limiter = (*rateLimiter)(nil)
+	_ Limiter = (*fixedWindowLimiter)(nil)
+	_ Limiter = (*slidingLogLimiter)(nil)
+	_ Limiter = (*slidingWindowLimiter)(nil)
+	_ Limiter = (*gcraLimiter)(nil)
+	_ Limiter = (*leakyBucketLimiter)(nil)
+)
+
+// limiterBase carries the locking and shutdown plumbing shared by the
+// window based limiters.
+type limiterBase struct {
+	clock    clock.Clock
+	mu       sync.Mutex
+	quitChan chan struct{}
+	isClosed bool
+}
+
+func newLimiterBase(clk clock.Clock) limiterBase {
+	return limiterBase{clock: clk, quitChan: make(chan struct{})}
+}
+
+// Close wakes up every blocked waiter and makes further calls fail.
+// It is safe to call Close more than once.
+func (b *limiterBase) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.isClosed {
+		return
+	}
+	b.isClosed = true
+	close(b.quitChan)
+}
+
+// waitFor calls try until it admits the request. try returns how long the
+// caller should back off before asking again, or zero once the request has
+// been admitted.
+func waitFor(ctx context.Context, clk clock.Clock, quitChan <-chan struct{}, try func(now time.Time) (time.Duration, error)) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		wait, err := try(clk.Now())
+		if err != nil {
+			return err
+		}
+		if wait <= 0 {
+			return nil
+		}
+
+		timer := clk.NewTimer(wait)
+		select {
+		case <-timer.C():
+		case <-quitChan:
+			timer.Stop()
+			return errLimiterClosed
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+// limitConfig is the JSON shape of one limiter's settings. Fields left out
+// are not changed.
+type limitConfig struct {
+	Rate  *float64 `json:"rate,omitempty"`
+	Burst *int     `json:"burst,omitempty"`
+}
+
+// limiterRegistry tracks named limiters so that their limits can be
+// changed at runtime, either through an admin HTTP endpoint or by
+// watching a JSON config file of the form
+//
+//	{"api": {"rate": 10, "burst": 20}, "export": {"rate": 0.5}}
+type limiterRegistry struct {
+	mu       sync.RWMutex
+	limiters map[string]*rateLimiter
+	// clock drives WatchFile's polling.
+	clock clock.Clock
+}
+
+func newLimiterRegistry(clk clock.Clock) *limiterRegistry {
+	return &limiterRegistry{limiters: make(map[string]*rateLimiter), clock: clk}
+}
+
+func (reg *limiterRegistry) Register(name string, l *rateLimiter) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.limiters[name] = l
+}
+
+func (reg *limiterRegistry) Get(name string) (*rateLimiter, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	l, ok := reg.limiters[name]
+	return l, ok
+}
+
+// Apply pushes cfg into the named limiters. Every name is checked before
+// anything is changed, so a config with an unknown name or an invalid
+// value is rejected as a whole.
+func (reg *limiterRegistry) Apply(cfg map[string]limitConfig) error {
+	// Take the write lock so that two Applies touching the same limiter
+	// cannot mix their halves of a {rate, burst} pair.
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	var errs []error
+	for name, c := range cfg {
+		if _, ok := reg.limiters[name]; !ok {
+			errs = append(errs, fmt.Errorf("unknown limiter %q", name))
+		}
+		if c.Rate != nil && *c.Rate < 0 {
+			errs = append(errs, fmt.Errorf("limiter %q: rate must not be negative", name))
+		}
+		if c.Burst != nil && *c.Burst <= 0 {
+			errs = append(errs, fmt.Errorf("limiter %q: burst must be positive", name))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	for name, c := range cfg {
+		l := reg.limiters[name]
+		rate, burst := l.Limits()
+		if c.Rate != nil {
+			rate = *c.Rate
+		}
+		if c.Burst != nil {
+			burst = *c.Burst
+		}
+		l.SetLimits(rate, burst)
+	}
+	return nil
+}
+
+// Snapshot returns the current limits of every registered limiter.
+func (reg *limiterRegistry) Snapshot() map[string]limitConfig {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	out := make(map[string]limitConfig, len(reg.limiters))
+	for name, l := range reg.limiters {
+		rate, burst := l.Limits()
+		out[name] = limitConfig{Rate: &rate, Burst: &burst}
+	}
+	return out
+}
+
+// ServeHTTP is the admin endpoint: GET returns the current limits and
+// PUT or POST applies a JSON config.
+func (reg *limiterRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var cfg map[string]limitConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := reg.Apply(cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reg.Snapshot())
+}
+
+// LoadFile applies the JSON config stored at path.
+func (reg *limiterRegistry) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var cfg map[string]limitConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	return reg.Apply(cfg)
+}
+
+// WatchFile polls path every interval and re-applies it whenever its
+// modification time changes, until ctx is done. A broken config is logged
+// and the previous limits stay in place.
+func (reg *limiterRegistry) WatchFile(ctx context.Context, path string, interval time.Duration) {
+	var lastMod time.Time
+
+	ticker := reg.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			log.Println("unable to stat limiter config ", err)
+		case !info.ModTime().Equal(lastMod):
+			lastMod = info.ModTime()
+			if err := reg.LoadFile(path); err != nil {
+				log.Println("unable to apply limiter config ", err)
+			}
+		}
+
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return
+		}
+	}
+}
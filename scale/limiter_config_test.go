@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+func TestSetRateWhileWaiting(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	l := newRateLimiter(1, 1, clk)
+	defer l.Close()
+	l.Allow()
+
+	done := make(chan error, 1)
+	go func() { done <- l.WaitN(context.Background(), 1) }()
+	clk.BlockUntil(1)
+
+	// At 10/s the token the waiter needs arrives after 100ms instead of 1s.
+	l.SetRate(10)
+	clk.Advance(99 * time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("WaitN returned %v after 99ms, want it to wait 100ms", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	clk.Advance(time.Millisecond)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("WaitN = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WaitN still blocked after the new rate earned its token")
+	}
+}
+
+func TestSetBurst(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	l := newRateLimiter(1, 10, clk)
+	defer l.Close()
+
+	clk.Advance(time.Minute)
+	if got := l.Tokens(); got != 10 {
+		t.Fatalf("Tokens = %v, want a full bucket of 10", got)
+	}
+
+	// Shrinking drops what no longer fits.
+	l.SetBurst(4)
+	if got := l.Tokens(); got != 4 {
+		t.Fatalf("Tokens = %v after shrinking to 4, want 4", got)
+	}
+
+	// Growing mints nothing; the room fills at the rate as usual.
+	l.SetBurst(8)
+	if got := l.Tokens(); got != 4 {
+		t.Fatalf("Tokens = %v right after growing to 8, want 4", got)
+	}
+	clk.Advance(2 * time.Second)
+	if got := l.Tokens(); got != 6 {
+		t.Fatalf("Tokens = %v 2s after growing, want 6", got)
+	}
+	clk.Advance(time.Minute)
+	if got := l.Tokens(); got != 8 {
+		t.Fatalf("Tokens = %v, want a full bucket of 8", got)
+	}
+
+	// Everything the bucket holds can be taken, and nothing more.
+	n := 0
+	for l.Allow() {
+		n++
+	}
+	if n != 8 {
+		t.Fatalf("%d tokens taken, want 8", n)
+	}
+}
+
+func TestSetLimits(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	l := newRateLimiter(1, 1, clk)
+	defer l.Close()
+
+	// Readers must never see the rate of one pair with the burst of the
+	// other.
+	pairs := [][2]int{{1, 1}, {50, 50}}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 1000 {
+			p := pairs[i%2]
+			l.SetLimits(float64(p[0]), p[1])
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			if rate, burst := l.Limits(); rate != 50 || burst != 50 {
+				t.Fatalf("Limits = %v, %d, want the last pair 50, 50", rate, burst)
+			}
+			return
+		default:
+		}
+		if rate, burst := l.Limits(); int(rate) != burst {
+			t.Fatalf("Limits = %v, %d: a half-applied pair", rate, burst)
+		}
+	}
+}
+
+func TestSetLimitsWakesWaiter(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	l := newRateLimiter(1, 1, clk)
+	defer l.Close()
+	l.Allow()
+
+	done := make(chan error, 1)
+	go func() { done <- l.Wait(context.Background()) }()
+	clk.BlockUntil(1)
+
+	// At 10/s the missing token takes 100ms instead of 1s.
+	l.SetLimits(10, 5)
+	clk.BlockUntil(1)
+	clk.Advance(100 * time.Millisecond)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Wait = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait still blocked after the new rate earned its token")
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func newTestRegistry(clk clock.Clock) (*limiterRegistry, *rateLimiter, *rateLimiter) {
+	reg := newLimiterRegistry(clk)
+	api, export := newRateLimiter(10, 20, clk), newRateLimiter(1, 1, clk)
+	reg.Register("api", api)
+	reg.Register("export", export)
+	return reg, api, export
+}
+
+func TestRegistryApplyAllOrNothing(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  map[string]limitConfig
+	}{
+		{"unknown limiter", map[string]limitConfig{
+			"api":     {Rate: ptr(50.0)},
+			"missing": {Rate: ptr(1.0)},
+		}},
+		{"negative rate", map[string]limitConfig{
+			"api":    {Rate: ptr(50.0)},
+			"export": {Rate: ptr(-1.0)},
+		}},
+		{"zero burst", map[string]limitConfig{
+			"api":    {Burst: ptr(50)},
+			"export": {Burst: ptr(0)},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg, api, _ := newTestRegistry(clock.NewFake(epoch))
+
+			if err := reg.Apply(tt.cfg); err == nil {
+				t.Fatal("Apply accepted an invalid config")
+			}
+			if rate, burst := api.Limits(); rate != 10 || burst != 20 {
+				t.Fatalf("api limits = %v, %v after a rejected config, want 10, 20", rate, burst)
+			}
+		})
+	}
+}
+
+func TestRegistryServeHTTP(t *testing.T) {
+	reg, api, export := newTestRegistry(clock.NewFake(epoch))
+
+	do := func(method, body string) (*httptest.ResponseRecorder, map[string]limitConfig) {
+		rec := httptest.NewRecorder()
+		reg.ServeHTTP(rec, httptest.NewRequest(method, "/limits", strings.NewReader(body)))
+		var got map[string]limitConfig
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("%s: decode response: %v", method, err)
+			}
+		}
+		return rec, got
+	}
+
+	rec, got := do(http.MethodGet, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET: status %d", rec.Code)
+	}
+	if c := got["api"]; *c.Rate != 10 || *c.Burst != 20 {
+		t.Fatalf("GET: api = %v/%v, want 10/20", *c.Rate, *c.Burst)
+	}
+
+	rec, got = do(http.MethodPut, `{"export": {"rate": 0.5, "burst": 3}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT: status %d: %s", rec.Code, rec.Body)
+	}
+	if c := got["export"]; *c.Rate != 0.5 || *c.Burst != 3 {
+		t.Fatalf("PUT: response has export = %v/%v, want 0.5/3", *c.Rate, *c.Burst)
+	}
+	if rate, burst := export.Limits(); rate != 0.5 || burst != 3 {
+		t.Fatalf("PUT: export limits = %v, %v, want 0.5, 3", rate, burst)
+	}
+
+	rejected := []struct {
+		method, body string
+		status       int
+	}{
+		{http.MethodPut, `{"api": {"rate": -1}}`, http.StatusUnprocessableEntity},
+		{http.MethodPut, `{"api": `, http.StatusBadRequest},
+		{http.MethodDelete, "", http.StatusMethodNotAllowed},
+	}
+	for _, r := range rejected {
+		if rec, _ := do(r.method, r.body); rec.Code != r.status {
+			t.Errorf("%s %s: status %d, want %d", r.method, r.body, rec.Code, r.status)
+		}
+	}
+	if rate, burst := api.Limits(); rate != 10 || burst != 20 {
+		t.Fatalf("api limits = %v, %v after rejected requests, want 10, 20", rate, burst)
+	}
+}
+
+func TestRegistryWatchFile(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	reg, api, _ := newTestRegistry(clk)
+	path := filepath.Join(t.TempDir(), "limits.json")
+
+	write := func(cfg string, mod time.Time) {
+		if err := os.WriteFile(path, []byte(cfg), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// Pin the modification time so a fast rewrite is still noticed.
+		if err := os.Chtimes(path, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"api": {"rate": 5}}`, epoch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reg.WatchFile(ctx, path, time.Second)
+	clk.BlockUntil(1)
+
+	rateIs := func(want float64) func() bool {
+		return func() bool {
+			rate, _ := api.Limits()
+			return rate == want
+		}
+	}
+	eventually(t, rateIs(5), "initial config was not applied")
+
+	// The change is only seen on the next poll.
+	write(`{"api": {"rate": 7, "burst": 2}}`, epoch.Add(time.Second))
+	time.Sleep(10 * time.Millisecond)
+	if rate, _ := api.Limits(); rate != 5 {
+		t.Fatalf("rate = %v before the next poll, want 5", rate)
+	}
+
+	clk.Advance(time.Second)
+	eventually(t, rateIs(7), "changed config was not applied")
+	if _, burst := api.Limits(); burst != 2 {
+		t.Fatalf("burst = %d, want 2", burst)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+// limiterConstructors build every Limiter with the same budget of 5
+// requests per second.
+var limiterConstructors = []struct {
+	name string
+	new  func(clk clock.Clock) Limiter
+}{
+	{"token bucket", func(clk clock.Clock) Limiter { return newRateLimiter(5, 5, clk) }},
+	{"fixed window", func(clk clock.Clock) Limiter { return newFixedWindowLimiter(5, time.Second, clk) }},
+	{"sliding log", func(clk clock.Clock) Limiter { return newSlidingLogLimiter(5, time.Second, clk) }},
+	{"sliding window", func(clk clock.Clock) Limiter { return newSlidingWindowLimiter(5, time.Second, clk) }},
+	{"gcra", func(clk clock.Clock) Limiter { return newGCRALimiter(5, 5, clk) }},
+	{"leaky bucket", func(clk clock.Clock) Limiter { return newLeakyBucketLimiter(5, 5, clk) }},
+}
+
+// TestLimiterTrace drives every limiter through the same burst of 10 Allow
+// calls at each step of a trace that straddles a window boundary.
+func TestLimiterTrace(t *testing.T) {
+	// steps are offsets from epoch, which is aligned to the second.
+	steps := []time.Duration{
+		900 * time.Millisecond,
+		1000 * time.Millisecond,
+		1200 * time.Millisecond,
+		2000 * time.Millisecond,
+	}
+
+	// allowed is how many of the 10 calls each limiter admits at each step,
+	// by the name in limiterConstructors.
+	allowed := map[string][]int{
+		"token bucket": {5, 0, 1, 4},
+		// Both windows' limits are spent within 100ms of each other.
+		"fixed window":   {5, 5, 0, 5},
+		"sliding log":    {5, 0, 0, 5},
+		"sliding window": {5, 0, 1, 4},
+		"gcra":           {5, 0, 1, 4},
+		// Allow never queues, so one request per interval gets through.
+		"leaky bucket": {1, 0, 1, 1},
+	}
+	if len(allowed) != len(limiterConstructors) {
+		t.Fatalf("trace covers %d limiters, limiterConstructors has %d", len(allowed), len(limiterConstructors))
+	}
+
+	for _, tc := range limiterConstructors {
+		t.Run(tc.name, func(t *testing.T) {
+			want, ok := allowed[tc.name]
+			if !ok {
+				t.Fatalf("no trace for %q", tc.name)
+			}
+
+			clk := clock.NewFake(epoch)
+			l := tc.new(clk)
+			defer l.Close()
+
+			for step, at := range steps {
+				clk.Set(epoch.Add(at))
+				got := 0
+				for range 10 {
+					if l.Allow() {
+						got++
+					}
+				}
+				if got != want[step] {
+					t.Errorf("at %v: %d allowed, want %d", at, got, want[step])
+				}
+			}
+		})
+	}
+}
+
+func TestFixedWindowBoundaryBurst(t *testing.T) {
+	clk := clock.NewFake(epoch.Add(999 * time.Millisecond))
+	l := newFixedWindowLimiter(5, time.Second, clk)
+	defer l.Close()
+
+	got := 0
+	for range 10 {
+		if l.Allow() {
+			got++
+		}
+	}
+	clk.Advance(time.Millisecond)
+	for range 10 {
+		if l.Allow() {
+			got++
+		}
+	}
+
+	if got != 10 {
+		t.Fatalf("%d allowed within 1ms around the boundary, want 2*limit = 10", got)
+	}
+}
+
+func TestLeakyBucketQueueFull(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	l := newLeakyBucketLimiter(1, 2, clk)
+	defer l.Close()
+
+	ctx := context.Background()
+
+	// The first request leaves right away, the second queues for 1s.
+	if err := l.Wait(ctx); err != nil {
+		t.Fatalf("first Wait = %v", err)
+	}
+	queued := make(chan error, 1)
+	go func() { queued <- l.Wait(ctx) }()
+	clk.BlockUntil(1)
+
+	// A third would be the second still queued, which is the capacity.
+	if err := l.Wait(ctx); !errors.Is(err, errQueueFull) {
+		t.Fatalf("Wait on a full queue = %v, want errQueueFull", err)
+	}
+
+	clk.Advance(time.Second)
+	if err := <-queued; err != nil {
+		t.Fatalf("queued Wait = %v", err)
+	}
+}
+
+func TestLimiterWaitAndClose(t *testing.T) {
+	for _, tc := range limiterConstructors {
+		t.Run(tc.name, func(t *testing.T) {
+			clk := clock.NewFake(epoch)
+			l := tc.new(clk)
+
+			for l.Allow() {
+			}
+
+			// An exhausted limiter admits a waiter once time moves on. Some
+			// limiters re-check and sleep again, so step the clock until
+			// the waiter is through.
+			done := make(chan error, 1)
+			go func() { done <- l.Wait(context.Background()) }()
+			clk.BlockUntil(1)
+			start := clk.Now()
+		wait:
+			for {
+				select {
+				case err := <-done:
+					if err != nil {
+						t.Fatalf("Wait = %v, want nil", err)
+					}
+					break wait
+				case <-time.After(10 * time.Millisecond):
+					if clk.Since(start) >= 2*time.Second {
+						t.Fatal("Wait still blocked after 2s")
+					}
+					clk.Advance(100 * time.Millisecond)
+				}
+			}
+
+			// Close releases a waiter that is still blocked.
+			for l.Allow() {
+			}
+			go func() { done <- l.Wait(context.Background()) }()
+			clk.BlockUntil(1)
+			l.Close()
+			if err := <-done; !errors.Is(err, errLimiterClosed) {
+				t.Fatalf("Wait = %v after Close, want errLimiterClosed", err)
+			}
+		})
+	}
+}
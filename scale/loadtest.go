@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+type loadTestConfig struct {
+	limiter     string
+	rate        float64
+	burst       int
+	clients     int
+	clientRate  float64
+	pattern     string
+	burstSize   int
+	duration    time.Duration
+	wait        bool
+	maxWait     time.Duration
+	format      string
+	output      string
+	clock       clock.Clock
+	arrivalSeed uint64
+}
+
+func parseLoadTestConfig(args []string) (loadTestConfig, error) {
+	cfg := loadTestConfig{clock: clock.Real()}
+
+	fs := flag.NewFlagSet("scale", flag.ContinueOnError)
+	fs.StringVar(&cfg.limiter, "limiter", "token-bucket", "algorithm: token-bucket, fixed-window, sliding-log, sliding-window, gcra or leaky-bucket")
+	fs.Float64Var(&cfg.rate, "rate", 100, "requests per second allowed by the limiter")
+	fs.IntVar(&cfg.burst, "burst", 10, "burst size (queue capacity for leaky-bucket)")
+	fs.IntVar(&cfg.clients, "clients", 10, "number of concurrent clients")
+	fs.Float64Var(&cfg.clientRate, "client-rate", 20, "requests per second sent by each client")
+	fs.StringVar(&cfg.pattern, "pattern", "constant", "arrival pattern: constant, poisson or bursty")
+	fs.IntVar(&cfg.burstSize, "burst-size", 10, "requests per burst for the bursty pattern")
+	fs.DurationVar(&cfg.duration, "duration", 5*time.Second, "how long to generate load")
+	fs.BoolVar(&cfg.wait, "wait", false, "block in Wait instead of calling Allow")
+	fs.DurationVar(&cfg.maxWait, "max-wait", time.Second, "give up waiting after this long (with -wait)")
+	fs.StringVar(&cfg.format, "format", "text", "report format: text, csv or json")
+	fs.StringVar(&cfg.output, "o", "", "write the report to this file instead of stdout")
+	fs.Uint64Var(&cfg.arrivalSeed, "seed", uint64(time.Now().UnixNano()), "seed for the poisson arrival pattern")
+
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	switch {
+	case cfg.rate <= 0 || cfg.clientRate <= 0:
+		return cfg, errors.New("rates must be positive")
+	case cfg.clients <= 0 || cfg.burst <= 0 || cfg.burstSize <= 0:
+		return cfg, errors.New("clients, burst and burst-size must be positive")
+	case cfg.pattern != "constant" && cfg.pattern != "poisson" && cfg.pattern != "bursty":
+		return cfg, fmt.Errorf("unknown arrival pattern %q", cfg.pattern)
+	case cfg.format != "text" && cfg.format != "csv" && cfg.format != "json":
+		return cfg, fmt.Errorf("unknown format %q", cfg.format)
+	}
+	return cfg, nil
+}
+
+// newLimiterByName builds one of the package's Limiter implementations.
+// Window based algorithms get a window sized by windowFor.
+func newLimiterByName(name string, rate float64, burst int, clk clock.Clock) (Limiter, error) {
+	perWindow, window := windowFor(rate)
+
+	switch name {
+	case "token-bucket":
+		return newRateLimiter(rate, burst, clk), nil
+	case "fixed-window":
+		return newFixedWindowLimiter(perWindow, window, clk), nil
+	case "sliding-log":
+		return newSlidingLogLimiter(perWindow, window, clk), nil
+	case "sliding-window":
+		return newSlidingWindowLimiter(perWindow, window, clk), nil
+	case "gcra":
+		return newGCRALimiter(rate, burst, clk), nil
+	case "leaky-bucket":
+		return newLeakyBucketLimiter(rate, burst, clk), nil
+	}
+	return nil, fmt.Errorf("unknown limiter %q", name)
+}
+
+// windowFor turns a rate into a whole number of requests per window,
+// stretching the window to keep the rate: 100/s stays 100 per second,
+// 0.5/s becomes 1 per 2s and 2.5/s becomes 3 per 1.2s.
+func windowFor(rate float64) (int, time.Duration) {
+	limit := max(1, int(math.Round(rate)))
+	return limit, time.Duration(float64(limit) / rate * float64(time.Second))
+}
+
+// clockContext reports its deadline on the load test's clock instead of
+// the wall clock, so limiters that check ctx.Deadline agree with it.
+type clockContext struct {
+	context.Context
+	deadline time.Time
+}
+
+func (c clockContext) Deadline() (time.Time, bool) { return c.deadline, true }
+
+func (c clockContext) Err() error {
+	if c.Context.Err() == nil {
+		return nil
+	}
+	return context.Cause(c.Context)
+}
+
+// withClockTimeout is context.WithTimeout measured on clk, so that a fake
+// clock can drive the run's duration and every request's max wait.
+func withClockTimeout(parent context.Context, clk clock.Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	deadline := clk.Now().Add(d)
+	if parentDeadline, ok := parent.Deadline(); ok && parentDeadline.Before(deadline) {
+		deadline = parentDeadline
+	}
+
+	ctx, cancel := context.WithCancelCause(parent)
+	timer := clk.NewTimer(d)
+	go func() {
+		select {
+		case <-timer.C():
+			cancel(context.DeadlineExceeded)
+		case <-ctx.Done():
+		}
+	}()
+
+	return clockContext{Context: ctx, deadline: deadline}, func() {
+		timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// arrivals returns the gap before each of a client's requests.
+func arrivals(cfg loadTestConfig, id int) (func() time.Duration, error) {
+	interval := time.Duration(float64(time.Second) / cfg.clientRate)
+
+	switch cfg.pattern {
+	case "constant":
+		return func() time.Duration { return interval }, nil
+	case "poisson":
+		rng := rand.New(rand.NewPCG(cfg.arrivalSeed, uint64(id)))
+		return func() time.Duration {
+			return time.Duration(rng.ExpFloat64() * float64(interval))
+		}, nil
+	case "bursty":
+		// burstSize requests back to back, then a pause that keeps the
+		// average at clientRate.
+		sent := 0
+		return func() time.Duration {
+			sent++
+			if sent%cfg.burstSize == 0 {
+				return interval * time.Duration(cfg.burstSize)
+			}
+			return 0
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown arrival pattern %q", cfg.pattern)
+}
+
+type loadTestResult struct {
+	client  int
+	at      time.Duration
+	allowed bool
+	wait    time.Duration
+}
+
+type clientReport struct {
+	Client  int `json:"client"`
+	Allowed int `json:"allowed"`
+	Denied  int `json:"denied"`
+}
+
+type secondReport struct {
+	Second  int `json:"second"`
+	Allowed int `json:"allowed"`
+	Denied  int `json:"denied"`
+}
+
+type loadTestReport struct {
+	Limiter      string         `json:"limiter"`
+	Pattern      string         `json:"pattern"`
+	Clients      int            `json:"clients"`
+	Duration     time.Duration  `json:"duration_ns"`
+	Allowed      int            `json:"allowed"`
+	Denied       int            `json:"denied"`
+	AchievedRate float64        `json:"achieved_rate"`
+	WaitP50      time.Duration  `json:"wait_p50_ns"`
+	WaitP90      time.Duration  `json:"wait_p90_ns"`
+	WaitP99      time.Duration  `json:"wait_p99_ns"`
+	WaitMax      time.Duration  `json:"wait_max_ns"`
+	Fairness     float64        `json:"fairness"`
+	PerSecond    []secondReport `json:"per_second"`
+	PerClient    []clientReport `json:"per_client"`
+	results      []loadTestResult
+	elapsedTotal time.Duration
+}
+
+func runLoadTest(cfg loadTestConfig) (*loadTestReport, error) {
+	var wg sync.WaitGroup
+
+	limiter, err := newLimiterByName(cfg.limiter, cfg.rate, cfg.burst, cfg.clock)
+	if err != nil {
+		return nil, err
+	}
+	defer limiter.Close()
+
+	ctx, cancel := withClockTimeout(context.Background(), cfg.clock, cfg.duration)
+	defer cancel()
+
+	nexts := make([]func() time.Duration, cfg.clients)
+	for i := range nexts {
+		if nexts[i], err = arrivals(cfg, i); err != nil {
+			return nil, err
+		}
+	}
+
+	start := cfg.clock.Now()
+	perClient := make([][]loadTestResult, cfg.clients)
+
+	for i, next := range nexts {
+		wg.Add(1)
+		go func(id int, next func() time.Duration) {
+			defer wg.Done()
+
+			for {
+				issued := cfg.clock.Now()
+				res := loadTestResult{client: id, at: issued.Sub(start)}
+
+				if cfg.wait {
+					waitCtx, cancelWait := withClockTimeout(ctx, cfg.clock, cfg.maxWait)
+					res.allowed = limiter.Wait(waitCtx) == nil
+					cancelWait()
+					res.wait = cfg.clock.Since(issued)
+				} else {
+					res.allowed = limiter.Allow()
+				}
+
+				if ctx.Err() != nil {
+					return
+				}
+				perClient[id] = append(perClient[id], res)
+
+				select {
+				case <-cfg.clock.After(next()):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(i, next)
+	}
+	wg.Wait()
+
+	report := &loadTestReport{
+		Limiter:      cfg.limiter,
+		Pattern:      cfg.pattern,
+		Clients:      cfg.clients,
+		Duration:     cfg.duration,
+		elapsedTotal: cfg.clock.Since(start),
+	}
+	for _, results := range perClient {
+		report.results = append(report.results, results...)
+	}
+	report.summarize(cfg.clients)
+	return report, nil
+}
+
+func (rep *loadTestReport) summarize(clients int) {
+	rep.PerClient = make([]clientReport, clients)
+	for i := range rep.PerClient {
+		rep.PerClient[i].Client = i
+	}
+
+	waits := make([]time.Duration, 0, len(rep.results))
+	for _, res := range rep.results {
+		second := int(res.at / time.Second)
+		for len(rep.PerSecond) <= second {
+			rep.PerSecond = append(rep.PerSecond, secondReport{Second: len(rep.PerSecond)})
+		}
+
+		if res.allowed {
+			rep.Allowed++
+			rep.PerSecond[second].Allowed++
+			rep.PerClient[res.client].Allowed++
+			waits = append(waits, res.wait)
+		} else {
+			rep.Denied++
+			rep.PerSecond[second].Denied++
+			rep.PerClient[res.client].Denied++
+		}
+	}
+
+	if secs := rep.elapsedTotal.Seconds(); secs > 0 {
+		rep.AchievedRate = float64(rep.Allowed) / secs
+	}
+
+	slices.Sort(waits)
+	rep.WaitP50 = percentile(waits, 0.50)
+	rep.WaitP90 = percentile(waits, 0.90)
+	rep.WaitP99 = percentile(waits, 0.99)
+	if len(waits) > 0 {
+		rep.WaitMax = waits[len(waits)-1]
+	}
+
+	allowed := make([]float64, clients)
+	for i, c := range rep.PerClient {
+		allowed[i] = float64(c.Allowed)
+	}
+	rep.Fairness = jainIndex(allowed)
+}
+
+// percentile expects sorted values.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(0, idx)]
+}
+
+// jainIndex is Jain's fairness index: 1 when every client got the same
+// share, 1/n when a single client got everything.
+func jainIndex(xs []float64) float64 {
+	var sum, sumSq float64
+	for _, x := range xs {
+		sum += x
+		sumSq += x * x
+	}
+	if sumSq == 0 {
+		return 1
+	}
+	return sum * sum / (float64(len(xs)) * sumSq)
+}
+
+func (rep *loadTestReport) write(w io.Writer, format string) error {
+	switch format {
+	case "csv":
+		return rep.writeCSV(w)
+	case "json":
+		return rep.writeJSON(w)
+	}
+	return rep.writeText(w)
+}
+
+func (rep *loadTestReport) writeText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "limiter\t%s\n", rep.Limiter)
+	fmt.Fprintf(tw, "pattern\t%s\n", rep.Pattern)
+	fmt.Fprintf(tw, "clients\t%d\n", rep.Clients)
+	fmt.Fprintf(tw, "allowed\t%d\n", rep.Allowed)
+	fmt.Fprintf(tw, "denied\t%d\n", rep.Denied)
+	fmt.Fprintf(tw, "achieved rate\t%.2f/s\n", rep.AchievedRate)
+	fmt.Fprintf(tw, "wait p50/p90/p99/max\t%s / %s / %s / %s\n", rep.WaitP50, rep.WaitP90, rep.WaitP99, rep.WaitMax)
+	fmt.Fprintf(tw, "fairness (Jain)\t%.3f\n\n", rep.Fairness)
+
+	fmt.Fprintln(tw, "second\tallowed\tdenied")
+	for _, s := range rep.PerSecond {
+		fmt.Fprintf(tw, "%d\t%d\t%d\n", s.Second, s.Allowed, s.Denied)
+	}
+
+	fmt.Fprintln(tw, "\nclient\tallowed\tdenied")
+	for _, c := range rep.PerClient {
+		fmt.Fprintf(tw, "%d\t%d\t%d\n", c.Client, c.Allowed, c.Denied)
+	}
+	return tw.Flush()
+}
+
+// writeCSV writes the per-second timeline, which is what gets plotted.
+func (rep *loadTestReport) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"second", "allowed", "denied"})
+	for _, s := range rep.PerSecond {
+		cw.Write([]string{strconv.Itoa(s.Second), strconv.Itoa(s.Allowed), strconv.Itoa(s.Denied)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (rep *loadTestReport) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rep)
+}
+
+func loadTestDriver(args []string) error {
+	cfg, err := parseLoadTestConfig(args)
+	if err != nil {
+		return err
+	}
+
+	report, err := runLoadTest(cfg)
+	if err != nil {
+		return err
+	}
+
+	if cfg.output == "" {
+		return report.write(os.Stdout, cfg.format)
+	}
+	f, err := os.Create(cfg.output)
+	if err != nil {
+		return err
+	}
+	// A failed Close can lose the tail of the report.
+	return errors.Join(report.write(f, cfg.format), f.Close())
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+func TestArrivals(t *testing.T) {
+	cfg := loadTestConfig{clientRate: 10, burstSize: 3}
+
+	cfg.pattern = "constant"
+	next, err := arrivals(cfg, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if gap := next(); gap != 100*time.Millisecond {
+			t.Fatalf("constant gap = %v, want 100ms", gap)
+		}
+	}
+
+	// Bursts of three back to back, then a pause for all three.
+	cfg.pattern = "bursty"
+	if next, err = arrivals(cfg, 0); err != nil {
+		t.Fatal(err)
+	}
+	var gaps []time.Duration
+	for range 6 {
+		gaps = append(gaps, next())
+	}
+	want := []time.Duration{0, 0, 300 * time.Millisecond, 0, 0, 300 * time.Millisecond}
+	if !slices.Equal(gaps, want) {
+		t.Fatalf("bursty gaps = %v, want %v", gaps, want)
+	}
+
+	// Poisson gaps are repeatable per seed and client, and average out
+	// at the client rate.
+	cfg.pattern = "poisson"
+	cfg.arrivalSeed = 42
+	a, _ := arrivals(cfg, 1)
+	b, _ := arrivals(cfg, 1)
+	other, _ := arrivals(cfg, 2)
+	var sum time.Duration
+	differs := false
+	const n = 10000
+	for range n {
+		gap := a()
+		if gap != b() {
+			t.Fatal("poisson gaps differ for the same seed and client")
+		}
+		if gap != other() {
+			differs = true
+		}
+		sum += gap
+	}
+	if !differs {
+		t.Fatal("poisson gaps are the same for different clients")
+	}
+	if mean := sum / n; mean < 95*time.Millisecond || mean > 105*time.Millisecond {
+		t.Fatalf("mean poisson gap = %v, want about 100ms", mean)
+	}
+
+	cfg.pattern = "random"
+	if _, err := arrivals(cfg, 0); err == nil {
+		t.Fatal("arrivals accepted an unknown pattern")
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, 1},
+		{0.1, 1},
+		{0.5, 5},
+		{0.51, 6},
+		{0.9, 9},
+		{0.99, 10},
+		{1, 10},
+	}
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(1..10, %v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	if got := percentile(nil, 0.5); got != 0 {
+		t.Errorf("percentile(nil) = %v, want 0", got)
+	}
+}
+
+func TestJainIndex(t *testing.T) {
+	tests := []struct {
+		name string
+		xs   []float64
+		want float64
+	}{
+		{"equal shares", []float64{5, 5, 5, 5}, 1},
+		{"one client gets everything", []float64{8, 0, 0, 0}, 0.25},
+		{"half and half", []float64{1, 1, 0, 0}, 0.5},
+		{"nothing allowed", []float64{0, 0}, 1},
+		{"uneven", []float64{1, 3}, 0.8},
+	}
+	for _, tt := range tests {
+		if got := jainIndex(tt.xs); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: jainIndex(%v) = %v, want %v", tt.name, tt.xs, got, tt.want)
+		}
+	}
+}
+
+// testReport is two clients over two seconds, with client 0 getting
+// everything that was allowed.
+func testReport() *loadTestReport {
+	rep := &loadTestReport{
+		Limiter:      "token-bucket",
+		Pattern:      "constant",
+		Clients:      2,
+		Duration:     2 * time.Second,
+		elapsedTotal: 2 * time.Second,
+		results: []loadTestResult{
+			{client: 0, at: 0, allowed: true, wait: 10 * time.Millisecond},
+			{client: 1, at: 100 * time.Millisecond, allowed: false},
+			{client: 0, at: 1500 * time.Millisecond, allowed: true, wait: 30 * time.Millisecond},
+			{client: 0, at: 1600 * time.Millisecond, allowed: true, wait: 20 * time.Millisecond},
+			{client: 1, at: 1700 * time.Millisecond, allowed: false},
+		},
+	}
+	rep.summarize(rep.Clients)
+	return rep
+}
+
+func TestSummarize(t *testing.T) {
+	rep := testReport()
+
+	if rep.Allowed != 3 || rep.Denied != 2 {
+		t.Fatalf("allowed/denied = %d/%d, want 3/2", rep.Allowed, rep.Denied)
+	}
+	if rep.AchievedRate != 1.5 {
+		t.Fatalf("AchievedRate = %v, want 1.5", rep.AchievedRate)
+	}
+	wantSeconds := []secondReport{{Second: 0, Allowed: 1, Denied: 1}, {Second: 1, Allowed: 2, Denied: 1}}
+	if !slices.Equal(rep.PerSecond, wantSeconds) {
+		t.Fatalf("PerSecond = %+v, want %+v", rep.PerSecond, wantSeconds)
+	}
+	wantClients := []clientReport{{Client: 0, Allowed: 3}, {Client: 1, Denied: 2}}
+	if !slices.Equal(rep.PerClient, wantClients) {
+		t.Fatalf("PerClient = %+v, want %+v", rep.PerClient, wantClients)
+	}
+	// Only allowed requests count towards the waits.
+	if rep.WaitP50 != 20*time.Millisecond || rep.WaitP99 != 30*time.Millisecond || rep.WaitMax != 30*time.Millisecond {
+		t.Fatalf("waits p50/p99/max = %v/%v/%v, want 20ms/30ms/30ms", rep.WaitP50, rep.WaitP99, rep.WaitMax)
+	}
+	if rep.Fairness != 0.5 {
+		t.Fatalf("Fairness = %v, want 0.5", rep.Fairness)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testReport().writeCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "second,allowed,denied\n0,1,1\n1,2,1\n"
+	if buf.String() != want {
+		t.Fatalf("writeCSV wrote\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testReport().writeJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]any{
+		"limiter":     "token-bucket",
+		"allowed":     3.0,
+		"denied":      2.0,
+		"duration_ns": float64(2 * time.Second),
+		"wait_max_ns": float64(30 * time.Millisecond),
+		"fairness":    0.5,
+	} {
+		if got[key] != want {
+			t.Errorf("%s = %v, want %v", key, got[key], want)
+		}
+	}
+	if perSecond, _ := got["per_second"].([]any); len(perSecond) != 2 {
+		t.Errorf("per_second = %v, want 2 entries", got["per_second"])
+	}
+	if _, ok := got["results"]; ok {
+		t.Error("raw results leaked into the JSON report")
+	}
+}
+
+func TestWindowFor(t *testing.T) {
+	tests := []struct {
+		rate   float64
+		limit  int
+		window time.Duration
+	}{
+		{100, 100, time.Second},
+		{1, 1, time.Second},
+		{0.5, 1, 2 * time.Second},
+		{0.25, 1, 4 * time.Second},
+		{2.5, 3, 1200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		limit, window := windowFor(tt.rate)
+		if limit != tt.limit || window != tt.window {
+			t.Errorf("windowFor(%v) = %d per %v, want %d per %v", tt.rate, limit, window, tt.limit, tt.window)
+		}
+	}
+}
+
+func TestWindowLimitersKeepFractionalRates(t *testing.T) {
+	for _, name := range []string{"fixed-window", "sliding-log", "sliding-window"} {
+		t.Run(name, func(t *testing.T) {
+			clk := clock.NewFake(epoch)
+			l, err := newLimiterByName(name, 0.5, 1, clk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			if !l.Allow() {
+				t.Fatal("first request denied")
+			}
+			clk.Advance(time.Second)
+			if l.Allow() {
+				t.Fatal("second request allowed after 1s at 0.5/s")
+			}
+			clk.Advance(3 * time.Second)
+			if !l.Allow() {
+				t.Fatal("request denied after 4s at 0.5/s")
+			}
+		})
+	}
+}
+
+func TestWithClockTimeout(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	ctx, cancel := withClockTimeout(context.Background(), clk, time.Second)
+	defer cancel()
+
+	if deadline, ok := ctx.Deadline(); !ok || !deadline.Equal(epoch.Add(time.Second)) {
+		t.Fatalf("Deadline = %v, %v; want %v on the clock", deadline, ok, epoch.Add(time.Second))
+	}
+	// A child can only shorten the deadline.
+	child, cancelChild := withClockTimeout(ctx, clk, time.Hour)
+	defer cancelChild()
+	if deadline, _ := child.Deadline(); !deadline.Equal(epoch.Add(time.Second)) {
+		t.Fatalf("child Deadline = %v, want the parent's %v", deadline, epoch.Add(time.Second))
+	}
+
+	clk.Advance(time.Second - time.Nanosecond)
+	if err := ctx.Err(); err != nil {
+		t.Fatalf("Err = %v before the deadline", err)
+	}
+	clk.Advance(time.Nanosecond)
+	<-child.Done()
+	if err := ctx.Err(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Err = %v after the deadline, want context.DeadlineExceeded", err)
+	}
+
+	early, cancelEarly := withClockTimeout(context.Background(), clk, time.Second)
+	cancelEarly()
+	if err := early.Err(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Err = %v after cancel, want context.Canceled", err)
+	}
+}
+
+// TestRunLoadTestOnFakeClock checks that the run length comes from the
+// injected clock: a 950ms run at 10 requests/s issues exactly 10 requests
+// however long the test takes in real time.
+func TestRunLoadTestOnFakeClock(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	cfg := loadTestConfig{
+		limiter:    "fixed-window",
+		rate:       5,
+		burst:      1,
+		clients:    1,
+		clientRate: 10,
+		pattern:    "constant",
+		duration:   950 * time.Millisecond,
+		clock:      clk,
+	}
+
+	done := make(chan *loadTestReport, 1)
+	go func() {
+		rep, err := runLoadTest(cfg)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- rep
+	}()
+
+	// The run's own deadline plus the client's pause between requests.
+	for range 9 {
+		clk.BlockUntil(2)
+		clk.Advance(100 * time.Millisecond)
+	}
+	clk.BlockUntil(2)
+	clk.Advance(50 * time.Millisecond)
+
+	rep := <-done
+	if rep.Allowed != 5 || rep.Denied != 5 {
+		t.Fatalf("allowed/denied = %d/%d, want 5/5", rep.Allowed, rep.Denied)
+	}
+	if rep.elapsedTotal != 950*time.Millisecond {
+		t.Fatalf("run took %v of clock time, want 950ms", rep.elapsedTotal)
+	}
+}
+
+func TestRunLoadTestMaxWaitOnFakeClock(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	cfg := loadTestConfig{
+		limiter:    "fixed-window",
+		rate:       1,
+		burst:      1,
+		clients:    1,
+		clientRate: 10,
+		pattern:    "constant",
+		duration:   950 * time.Millisecond,
+		wait:       true,
+		maxWait:    300 * time.Millisecond,
+		clock:      clk,
+	}
+
+	done := make(chan *loadTestReport, 1)
+	go func() {
+		rep, err := runLoadTest(cfg)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- rep
+	}()
+
+	var rep *loadTestReport
+	for rep == nil {
+		select {
+		case rep = <-done:
+		case <-time.After(time.Millisecond):
+			clk.Advance(10 * time.Millisecond)
+		}
+	}
+
+	if rep.Allowed != 1 || rep.Denied == 0 {
+		t.Fatalf("allowed/denied = %d/%d, want 1 and some denied", rep.Allowed, rep.Denied)
+	}
+	// Every denied request gave up after maxWait on the fake clock. The
+	// clock is stepped in 10ms, so the goroutine may notice a little late.
+	for _, res := range rep.results {
+		if !res.allowed && (res.wait < cfg.maxWait || res.wait > cfg.maxWait+100*time.Millisecond) {
+			t.Fatalf("denied request waited %v, want about %v", res.wait, cfg.maxWait)
+		}
+	}
+	if rep.elapsedTotal < cfg.duration || rep.elapsedTotal > 2*time.Second {
+		t.Fatalf("run took %v of clock time, want about %v", rep.elapsedTotal, cfg.duration)
+	}
+}
+
+func TestLoadTestDriverOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.csv")
+	err := loadTestDriver([]string{"-duration", "50ms", "-clients", "1", "-format", "csv", "-o", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "second,allowed,denied\n0,") {
+		t.Fatalf("report file = %q, want the CSV timeline", data)
+	}
+
+	err = loadTestDriver([]string{"-duration", "10ms", "-o", filepath.Join(t.TempDir(), "missing", "report.txt")})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("loadTestDriver = %v, want the create error", err)
+	}
+}
@@ -1,5 +1,30 @@
 package main
 
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+)
+
+// Usage:
+//
+//	go run . [flags]   drive a limiter with simulated clients and report
+//	go run . demo      run the rate limiting and bandwidth examples
 func main() {
-	rateLimitDriver()
+	if len(os.Args) > 1 && os.Args[1] == "demo" {
+		rateLimitDriver()
+		bandwidthDriver()
+		adaptiveLimitDriver()
+		hierarchicalLimitDriver()
+		return
+	}
+
+	err := loadTestDriver(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 }
@@ -1,65 +1,418 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+	"github.com/VarthanV/go-concurrency-exercises/ratelimit"
+)
+
+// infDuration is returned by a reservation that can never be acted on.
+const infDuration = time.Duration(math.MaxInt64)
+
+var (
+	errLimiterClosed  = errors.New("rate limiter is closed")
+	errExceedsBurst   = errors.New("requested tokens exceed burst rate")
+	errInvalidRequest = errors.New("requested tokens must be positive")
 )
 
+// rateLimiter is a token bucket that refills lazily. Instead of a ticker
+// minting one token at a time, the number of tokens is recomputed from the
+// time elapsed since the last call, so there is no background goroutine and
+// any positive rate (including fractional ones below 1/s) works.
 type rateLimiter struct {
-	burstRate     int
-	rate          int
-	currentTokens int
-	mu            sync.Mutex
-	ticker        *time.Ticker
-	quitChan      <-chan struct{}
-	isClosed      bool
+	burstRate int
+	// rate is the number of tokens added to the bucket per second.
+	rate          float64
+	currentTokens float64
+	lastUpdate    time.Time
+	// minted counts every token ever added to the bucket. Reservations
+	// record the value minted has to reach before they may act, which keeps
+	// them valid across rate changes.
+	minted float64
+	// lastMark is the highest mark handed out to a reservation.
+	lastMark float64
+	// reconfigured is closed and replaced whenever the limits change so
+	// that blocked waiters recompute their delay.
+	reconfigured chan struct{}
+	clock        clock.Clock
+	mu           sync.Mutex
+	quitChan     chan struct{}
+	isClosed     bool
 }
 
-func (r *rateLimiter) New(rate, burstRate int) *rateLimiter {
-	rl := &rateLimiter{
+func (r *rateLimiter) New(rate float64, burstRate int) *rateLimiter {
+	return newRateLimiter(rate, burstRate, clock.Real())
+}
+
+// newRateLimiter is New with an explicit clock, so tests can drive the
+// bucket with a clock.Fake.
+func newRateLimiter(rate float64, burstRate int, clk clock.Clock) *rateLimiter {
+	return &rateLimiter{
 		burstRate:     burstRate,
 		rate:          rate,
-		currentTokens: rate,
-		quitChan:      make(<-chan struct{}),
-		ticker:        time.NewTicker(time.Second / time.Duration(rate)),
+		currentTokens: math.Min(rate, float64(burstRate)),
+		lastUpdate:    clk.Now(),
+		clock:         clk,
+		reconfigured:  make(chan struct{}),
+		quitChan:      make(chan struct{}),
 		isClosed:      false,
 	}
+}
 
-	go rl.refill()
-	return rl
+// advance adds the tokens earned between lastUpdate and now. Must be
+// called with mu held.
+func (r *rateLimiter) advance(now time.Time) {
+	if !now.After(r.lastUpdate) {
+		return
+	}
+	burst := float64(r.burstRate)
+	if r.currentTokens < burst {
+		elapsed := now.Sub(r.lastUpdate).Seconds()
+		tokens := math.Min(burst, r.currentTokens+elapsed*r.rate)
+		r.minted += tokens - r.currentTokens
+		r.currentTokens = tokens
+	}
+	r.lastUpdate = now
 }
 
-func (r *rateLimiter) refill() {
-	for {
-		select {
-		case <-r.ticker.C:
-			r.mu.Lock()
-			if r.burstRate > r.currentTokens {
-				r.currentTokens++
-			}
-			r.mu.Unlock()
-		case <-r.quitChan:
-			r.ticker.Stop()
-			return
-		}
+// durationFor returns how long it takes to earn the given number of
+// tokens at the configured rate.
+func (r *rateLimiter) durationFor(tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	if r.rate <= 0 {
+		return infDuration
 	}
+	seconds := tokens / r.rate
+	if seconds >= float64(math.MaxInt64)/float64(time.Second) {
+		return infDuration
+	}
+	return time.Duration(seconds * float64(time.Second))
 }
 
+// Tokens returns the number of tokens currently in the bucket. It is
+// negative while reservations are waiting on the deficit.
+func (r *rateLimiter) Tokens() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.advance(r.clock.Now())
+	return r.currentTokens
+}
+
+// Allow takes a token if one is available right now. Unlike Reserve it
+// does not allocate, which keeps the hot path cheap.
 func (r *rateLimiter) Allow() bool {
+	now := r.clock.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.takeLocked(now, 1, 0)
+	return ok
+}
+
+// Reserve is shorthand for ReserveN(1).
+func (r *rateLimiter) Reserve() *reservation {
+	return r.ReserveN(1)
+}
+
+// ReserveN takes n tokens from the bucket right away, even if the bucket
+// does not hold them yet, and tells the caller when it may act on them.
+// The returned reservation is not OK if n exceeds the burst rate or the
+// limiter is closed.
+func (r *rateLimiter) ReserveN(n int) *reservation {
+	return r.reserveN(r.clock.Now(), n, infDuration)
+}
+
+// reserveN reserves n tokens as of now. If the caller would have to wait
+// longer than maxWait the bucket is left untouched and the reservation is
+// not OK.
+func (r *rateLimiter) reserveN(now time.Time, n int, maxWait time.Duration) *reservation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.reserveLocked(now, n, maxWait)
+}
+
+// waitLocked returns how long a reservation of n tokens made now would
+// have to wait, and false if it can never be granted. Must be called with
+// mu held.
+func (r *rateLimiter) waitLocked(now time.Time, n int) (time.Duration, bool) {
+	if r.isClosed || n <= 0 || n > r.burstRate {
+		return infDuration, false
+	}
+
+	r.advance(now)
+	return r.durationFor(float64(n) - r.currentTokens), true
+}
+
+// reserveLocked is reserveN for callers that already hold mu.
+func (r *rateLimiter) reserveLocked(now time.Time, n int, maxWait time.Duration) *reservation {
+	mark, ok := r.takeLocked(now, n, maxWait)
+	return &reservation{
+		limiter: r,
+		ok:      ok,
+		tokens:  n,
+		mark:    mark,
+	}
+}
+
+// takeLocked takes n tokens if the caller would not have to wait longer
+// than maxWait, and returns the mark its reservation waits for. Must be
+// called with mu held.
+func (r *rateLimiter) takeLocked(now time.Time, n int, maxWait time.Duration) (float64, bool) {
+	wait, ok := r.waitLocked(now, n)
+	if !ok || wait > maxWait {
+		return 0, false
+	}
+
+	// Tokens may go negative: the deficit is what later callers have to
+	// wait out before they get anything.
+	remaining := r.currentTokens - float64(n)
+	r.currentTokens = remaining
+	mark := r.minted
+	if remaining < 0 {
+		mark += -remaining
+	}
+	if mark > r.lastMark {
+		r.lastMark = mark
+	}
+	return mark, true
+}
+
+// delayFor returns how long a reservation with the given mark still has to
+// wait as of now. Must be called with mu held.
+func (r *rateLimiter) delayFor(now time.Time, mark float64) time.Duration {
+	r.advance(now)
+	return r.durationFor(mark - r.minted)
+}
+
+// refund gives back n tokens that were taken but not used, as far as no
+// reservation is still waiting for tokens to be minted: those would
+// otherwise be overtaken by whoever takes the refunded tokens.
+func (r *rateLimiter) refund(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.advance(r.clock.Now())
+	restore := float64(n) - math.Max(0, r.lastMark-r.minted)
+	if restore <= 0 {
+		return
+	}
+	r.currentTokens = math.Min(float64(r.burstRate), r.currentTokens+restore)
+}
+
+// SetRate changes the refill rate. Tokens earned so far are accounted at
+// the old rate, and waiters that are already blocked are rescheduled
+// against the new one.
+func (r *rateLimiter) SetRate(rate float64) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	if r.currentTokens > 0 {
-		r.currentTokens--
-		return true
+	r.advance(r.clock.Now())
+	r.rate = rate
+	r.notifyReconfigured()
+}
+
+// SetBurst changes the bucket size. Shrinking it drops the tokens above
+// the new size; growing it does not add any.
+func (r *rateLimiter) SetBurst(burstRate int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
+	r.advance(r.clock.Now())
+	r.setBurstLocked(burstRate)
+	r.notifyReconfigured()
+}
+
+// SetLimits changes the rate and the burst at once, like SetRate followed
+// by SetBurst, but without waiters ever seeing one changed and not the
+// other.
+func (r *rateLimiter) SetLimits(rate float64, burstRate int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.advance(r.clock.Now())
+	r.rate = rate
+	r.setBurstLocked(burstRate)
+	r.notifyReconfigured()
+}
+
+// setBurstLocked must be called with mu held.
+func (r *rateLimiter) setBurstLocked(burstRate int) {
+	r.burstRate = burstRate
+	if burst := float64(burstRate); r.currentTokens > burst {
+		r.currentTokens = burst
 	}
-	return false
 }
 
+// Limits returns the current rate and burst.
+func (r *rateLimiter) Limits() (float64, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rate, r.burstRate
+}
+
+// notifyReconfigured must be called with mu held.
+func (r *rateLimiter) notifyReconfigured() {
+	close(r.reconfigured)
+	r.reconfigured = make(chan struct{})
+}
+
+// Wait blocks until a single token is available or ctx is done.
+func (r *rateLimiter) Wait(ctx context.Context) error {
+	return r.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n tokens can be taken from the bucket at once.
+// It returns ctx.Err() if the context ends first and errLimiterClosed
+// if the limiter is closed while waiting. Tokens reserved for a waiter
+// that gives up are returned to the bucket.
+func (r *rateLimiter) WaitN(ctx context.Context, n int) error {
+	if n <= 0 {
+		return errInvalidRequest
+	}
+	if _, burstRate := r.Limits(); n > burstRate {
+		return errExceedsBurst
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	now := r.clock.Now()
+	maxWait := infDuration
+	if deadline, ok := ctx.Deadline(); ok {
+		maxWait = deadline.Sub(now)
+	}
+
+	res := r.reserveN(now, n, maxWait)
+	if !res.OK() {
+		r.mu.Lock()
+		closed := r.isClosed
+		r.mu.Unlock()
+		if closed {
+			return errLimiterClosed
+		}
+		return context.DeadlineExceeded
+	}
+
+	for {
+		r.mu.Lock()
+		delay := r.delayFor(r.clock.Now(), res.mark)
+		reconfigured := r.reconfigured
+		r.mu.Unlock()
+
+		if delay == 0 {
+			return nil
+		}
+
+		// A rate change moves the point at which our tokens are earned,
+		// so the delay is recomputed whenever the limiter is reconfigured.
+		timer := r.clock.NewTimer(delay)
+		select {
+		case <-timer.C():
+		case <-reconfigured:
+			timer.Stop()
+		case <-r.quitChan:
+			timer.Stop()
+			res.Cancel()
+			return errLimiterClosed
+		case <-ctx.Done():
+			timer.Stop()
+			res.Cancel()
+			return ctx.Err()
+		}
+	}
+}
+
+// Close wakes up every blocked waiter and makes further calls fail.
+// It is safe to call Close more than once.
 func (r *rateLimiter) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
+	if r.isClosed {
+		return
+	}
+	r.isClosed = true
+	close(r.quitChan)
 }
+
 func rateLimitDriver() {
+	serverLimiter := newKeyedRateLimiter(2, 2, time.Minute, 1024, clock.Real())
+	defer serverLimiter.Close()
+
+	server := httptest.NewServer(newRateLimitHandler(serverLimiter, keyByIP,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, "ok")
+		})))
+	defer server.Close()
 
+	get := func(client *http.Client) {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			log.Println("error in doing request ", err)
+			return
+		}
+		defer resp.Body.Close()
+		log.Printf("status: %d remaining: %s retry after: %s\n",
+			resp.StatusCode,
+			resp.Header.Get("X-RateLimit-Remaining"),
+			resp.Header.Get("Retry-After"))
+	}
+
+	fmt.Println("Unthrottled client")
+	for i := 0; i < 5; i++ {
+		get(http.DefaultClient)
+	}
+
+	time.Sleep(time.Second)
+
+	fmt.Println("Throttled client")
+	clientLimiter := (&rateLimiter{}).New(2, 1)
+	defer clientLimiter.Close()
+	throttled := &http.Client{Transport: &ratelimit.Transport{Limiter: clientLimiter}}
+	for i := 0; i < 5; i++ {
+		get(throttled)
+	}
+
+	// The client's limits can be changed while it runs through the
+	// registry's admin endpoint.
+	registry := newLimiterRegistry(clock.Real())
+	registry.Register("client", clientLimiter)
+	admin := httptest.NewServer(registry)
+	defer admin.Close()
+
+	req, err := http.NewRequest(http.MethodPut, admin.URL, strings.NewReader(`{"client": {"rate": 10, "burst": 5}}`))
+	if err != nil {
+		log.Println("error in building request ", err)
+		return
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Println("error in updating limits ", err)
+		return
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	fmt.Printf("Updated client limits: %s", body)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		get(throttled)
+	}
+	fmt.Println("5 requests at the new rate took", time.Since(start).Round(time.Millisecond))
 }
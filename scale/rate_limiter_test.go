@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestFractionalRate(t *testing.T) {
+	tests := []struct {
+		rate float64
+		// every is how long one token takes.
+		every time.Duration
+	}{
+		{rate: 0.5, every: 2 * time.Second},
+		{rate: 0.1, every: 10 * time.Second},
+		{rate: 1.0 / 60, every: time.Minute},
+	}
+
+	for _, tt := range tests {
+		clk := clock.NewFake(epoch)
+		l := newRateLimiter(tt.rate, 1, clk)
+
+		// The bucket starts with min(rate, burst) tokens, i.e. less than one,
+		// so the first token arrives after (1-rate)/rate seconds.
+		if l.Allow() {
+			t.Fatalf("rate %v: Allow on a fresh bucket holding %v tokens", tt.rate, tt.rate)
+		}
+		first := time.Duration((1 - tt.rate) / tt.rate * float64(time.Second))
+		clk.Advance(first - time.Millisecond)
+		if l.Allow() {
+			t.Fatalf("rate %v: Allow %v before the first token is earned", tt.rate, time.Millisecond)
+		}
+		clk.Advance(time.Millisecond)
+		if !l.Allow() {
+			t.Fatalf("rate %v: Allow denied after %v", tt.rate, first)
+		}
+
+		// From an empty bucket every further token takes 1/rate.
+		clk.Advance(tt.every - time.Millisecond)
+		if l.Allow() {
+			t.Fatalf("rate %v: Allow %v before the token is earned", tt.rate, time.Millisecond)
+		}
+		clk.Advance(time.Millisecond)
+		if !l.Allow() {
+			t.Fatalf("rate %v: Allow denied after %v", tt.rate, tt.every)
+		}
+		if l.Allow() {
+			t.Fatalf("rate %v: second Allow granted with an empty bucket", tt.rate)
+		}
+
+		// With an empty bucket a reservation has to wait exactly one token.
+		if got := l.Reserve().Delay(); got != tt.every {
+			t.Fatalf("rate %v: Reserve().Delay() = %v, want %v", tt.rate, got, tt.every)
+		}
+		l.Close()
+	}
+}
+
+func TestFractionalRateWait(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	l := newRateLimiter(0.25, 1, clk)
+	defer l.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- l.Wait(context.Background())
+	}()
+
+	// Tokens start at 0.25, so 0.75 tokens are missing: 3 seconds.
+	clk.BlockUntil(1)
+	clk.Advance(2 * time.Second)
+	select {
+	case err := <-done:
+		t.Fatalf("Wait returned %v after 2s, want it to block for 3s", err)
+	default:
+	}
+
+	clk.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Fatalf("Wait = %v", err)
+	}
+}
+
+// tickerBucket is the design rateLimiter replaced: a goroutine adds one
+// token per tick under the same mutex callers take. It is kept here as the
+// baseline for BenchmarkAllowParallel.
+type tickerBucket struct {
+	mu     sync.Mutex
+	tokens int
+	burst  int
+	ticker *time.Ticker
+	quit   chan struct{}
+}
+
+func newTickerBucket(rate, burst int) *tickerBucket {
+	b := &tickerBucket{
+		tokens: burst,
+		burst:  burst,
+		ticker: time.NewTicker(time.Second / time.Duration(rate)),
+		quit:   make(chan struct{}),
+	}
+	go func() {
+		for {
+			select {
+			case <-b.ticker.C:
+				b.mu.Lock()
+				if b.tokens < b.burst {
+					b.tokens++
+				}
+				b.mu.Unlock()
+			case <-b.quit:
+				b.ticker.Stop()
+				return
+			}
+		}
+	}()
+	return b
+}
+
+func (b *tickerBucket) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens > 0 {
+		b.tokens--
+		return true
+	}
+	return false
+}
+
+func (b *tickerBucket) Close() { close(b.quit) }
+
+// BenchmarkAllowParallel compares Allow under parallel callers for the
+// lazy bucket and the ticker baseline at a high rate, where the ticker
+// goroutine competes with callers for the mutex on every tick. The lazy
+// bucket reads the clock on every call, which is most of its cost.
+func BenchmarkAllowParallel(b *testing.B) {
+	const (
+		rate  = 1_000_000
+		burst = 1000
+	)
+
+	b.Run("lazy", func(b *testing.B) {
+		l := newRateLimiter(rate, burst, clock.Real())
+		defer l.Close()
+
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				l.Allow()
+			}
+		})
+	})
+
+	b.Run("ticker", func(b *testing.B) {
+		l := newTickerBucket(rate, burst)
+		defer l.Close()
+
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				l.Allow()
+			}
+		})
+	})
+}
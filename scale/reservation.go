@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// reservation holds tokens taken from a rateLimiter ahead of time. The
+// caller is expected to wait Delay() before acting, or Cancel() the
+// reservation if it no longer needs the tokens.
+type reservation struct {
+	limiter *rateLimiter
+	ok      bool
+	tokens  int
+	// mark is the value the limiter's minted counter has to reach before
+	// the reservation may act. Tracking it in tokens rather than as a
+	// point in time keeps it correct when the rate changes.
+	mark float64
+}
+
+// OK reports whether the limiter could grant the tokens at all.
+func (res *reservation) OK() bool {
+	return res.ok
+}
+
+// Delay is shorthand for DelayFrom with the limiter clock's current time.
+func (res *reservation) Delay() time.Duration {
+	return res.DelayFrom(res.limiter.clock.Now())
+}
+
+// DelayFrom returns how long the caller has to wait from t before acting
+// on the reservation at the limiter's current rate. A reservation that is
+// not OK never becomes usable, so infDuration is returned.
+func (res *reservation) DelayFrom(t time.Time) time.Duration {
+	if !res.ok {
+		return infDuration
+	}
+
+	r := res.limiter
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.delayFor(t, res.mark)
+}
+
+// Cancel is shorthand for CancelAt with the limiter clock's current time.
+func (res *reservation) Cancel() {
+	res.CancelAt(res.limiter.clock.Now())
+}
+
+// CancelAt gives the reserved tokens back to the bucket as far as
+// possible. Tokens that later reservations already rely on are not
+// returned, and a reservation that could already act is left alone.
+func (res *reservation) CancelAt(t time.Time) {
+	if !res.ok || res.tokens == 0 {
+		return
+	}
+
+	r := res.limiter
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.advance(t)
+	if r.minted >= res.mark {
+		return
+	}
+
+	// Reservations made after this one were queued behind our tokens;
+	// only the part not yet overtaken by them can be restored.
+	restore := float64(res.tokens) - (r.lastMark - res.mark)
+	res.tokens = 0
+	if restore <= 0 {
+		return
+	}
+
+	r.currentTokens += restore
+	if burst := float64(r.burstRate); r.currentTokens > burst {
+		r.currentTokens = burst
+	}
+
+	if res.mark == r.lastMark {
+		r.lastMark = math.Max(r.minted, res.mark-restore)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+// reserveThree returns a limiter earning one token a second with a single
+// token in the bucket, and three reservations of one token taken from it:
+// one that may act now and two queued 1s and 2s out.
+func reserveThree(t *testing.T) (*clock.Fake, *rateLimiter, [3]*reservation) {
+	t.Helper()
+	clk := clock.NewFake(epoch)
+	l := newRateLimiter(1, 1, clk)
+	t.Cleanup(l.Close)
+
+	var res [3]*reservation
+	for i := range res {
+		res[i] = l.Reserve()
+		if want := time.Duration(i) * time.Second; res[i].Delay() != want {
+			t.Fatalf("reservation %d: Delay() = %v, want %v", i, res[i].Delay(), want)
+		}
+	}
+	return clk, l, res
+}
+
+func TestCancelLastReservation(t *testing.T) {
+	_, l, res := reserveThree(t)
+
+	res[2].Cancel()
+	if got := l.Tokens(); got != -1 {
+		t.Fatalf("Tokens() = %v after cancelling the last reservation, want -1", got)
+	}
+	// The next reservation takes the cancelled one's place in the queue.
+	if got := l.Reserve().Delay(); got != 2*time.Second {
+		t.Fatalf("next reservation Delay() = %v, want 2s", got)
+	}
+}
+
+func TestCancelMiddleReservation(t *testing.T) {
+	_, l, res := reserveThree(t)
+
+	// The last reservation is queued behind the middle one's token, so
+	// nothing can be given back without moving it forward.
+	res[1].Cancel()
+	if got := l.Tokens(); got != -2 {
+		t.Fatalf("Tokens() = %v after cancelling the middle reservation, want -2", got)
+	}
+	if got := res[2].Delay(); got != 2*time.Second {
+		t.Fatalf("last reservation Delay() = %v, want it unchanged at 2s", got)
+	}
+	if got := l.Reserve().Delay(); got != 3*time.Second {
+		t.Fatalf("next reservation Delay() = %v, want 3s", got)
+	}
+
+	// Cancelling twice gives back nothing more.
+	res[2].Cancel()
+	res[2].Cancel()
+	if got := l.Reserve().Delay(); got != 4*time.Second {
+		t.Fatalf("Delay() = %v after cancelling a queued reservation twice, want 4s", got)
+	}
+}
+
+func TestCancelActionableReservation(t *testing.T) {
+	clk, l, res := reserveThree(t)
+
+	res[0].Cancel()
+	if got := l.Tokens(); got != -2 {
+		t.Fatalf("Tokens() = %v after cancelling a reservation that could act, want -2", got)
+	}
+
+	clk.Advance(time.Second)
+	res[1].Cancel()
+	if got := l.Tokens(); got != -1 {
+		t.Fatalf("Tokens() = %v after cancelling a reservation that became actionable, want -1", got)
+	}
+	if got := res[2].Delay(); got != time.Second {
+		t.Fatalf("last reservation Delay() = %v, want 1s", got)
+	}
+}
+
+func TestCancelRestoresUpToBurst(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	l := newRateLimiter(1, 3, clk)
+	defer l.Close()
+
+	// Tokens start at 1, so reserving 3 leaves a deficit of 2.
+	res := l.ReserveN(3)
+	if got := res.Delay(); got != 2*time.Second {
+		t.Fatalf("Delay() = %v, want 2s", got)
+	}
+
+	clk.Advance(time.Second)
+	res.Cancel()
+	if got := l.Tokens(); got != 2 {
+		t.Fatalf("Tokens() = %v after cancelling, want the 2 tokens held before", got)
+	}
+	if got := l.Reserve().Delay(); got != 0 {
+		t.Fatalf("Delay() = %v after cancelling, want 0", got)
+	}
+}
+
+func TestDelayAfterSetRate(t *testing.T) {
+	clk, l, res := reserveThree(t)
+
+	l.SetRate(2)
+	if got := res[1].Delay(); got != 500*time.Millisecond {
+		t.Fatalf("Delay() = %v after doubling the rate, want 500ms", got)
+	}
+	if got := res[2].Delay(); got != time.Second {
+		t.Fatalf("Delay() = %v after doubling the rate, want 1s", got)
+	}
+
+	// Half a token is earned at the fast rate, the rest at the slow one.
+	clk.Advance(250 * time.Millisecond)
+	l.SetRate(0.5)
+	if got := res[1].Delay(); got != time.Second {
+		t.Fatalf("Delay() = %v after slowing down, want 1s", got)
+	}
+	if got := res[1].DelayFrom(clk.Now().Add(400 * time.Millisecond)); got != 600*time.Millisecond {
+		t.Fatalf("DelayFrom(now+400ms) = %v, want 600ms", got)
+	}
+
+	l.SetRate(0)
+	if got := res[1].Delay(); got != infDuration {
+		t.Fatalf("Delay() = %v at rate 0, want infDuration", got)
+	}
+}
+
+func TestReservationNotOK(t *testing.T) {
+	l := newRateLimiter(1, 2, clock.NewFake(epoch))
+	defer l.Close()
+
+	res := l.ReserveN(3)
+	if res.OK() {
+		t.Fatal("ReserveN above burst is OK")
+	}
+	if got := res.Delay(); got != infDuration {
+		t.Fatalf("Delay() = %v, want infDuration", got)
+	}
+	res.Cancel()
+	if got := l.Tokens(); got != 1 {
+		t.Fatalf("Tokens() = %v after cancelling a reservation that is not OK, want 1", got)
+	}
+}
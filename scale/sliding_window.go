@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/VarthanV/go-concurrency-exercises/clock"
+)
+
+// slidingLogLimiter keeps the timestamp of every admitted request and
+// admits a new one only if fewer than limit fall inside the trailing
+// window. It is exact but needs memory proportional to limit.
+type slidingLogLimiter struct {
+	limiterBase
+	limit  int
+	window time.Duration
+	log    []time.Time
+}
+
+func newSlidingLogLimiter(limit int, window time.Duration, clk clock.Clock) *slidingLogLimiter {
+	return &slidingLogLimiter{
+		limiterBase: newLimiterBase(clk),
+		limit:       limit,
+		window:      window,
+		log:         make([]time.Time, 0, limit),
+	}
+}
+
+func (s *slidingLogLimiter) try(now time.Time) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.isClosed {
+		return 0, errLimiterClosed
+	}
+
+	cutoff := now.Add(-s.window)
+	expired := 0
+	for expired < len(s.log) && !s.log[expired].After(cutoff) {
+		expired++
+	}
+	s.log = append(s.log[:0], s.log[expired:]...)
+
+	if len(s.log) < s.limit {
+		s.log = append(s.log, now)
+		return 0, nil
+	}
+	return s.log[0].Add(s.window).Sub(now), nil
+}
+
+func (s *slidingLogLimiter) Allow() bool {
+	wait, err := s.try(s.clock.Now())
+	return err == nil && wait <= 0
+}
+
+func (s *slidingLogLimiter) Wait(ctx context.Context) error {
+	return waitFor(ctx, s.clock, s.quitChan, s.try)
+}
+
+// slidingWindowLimiter approximates the sliding log with two counters: the
+// previous window's count is weighted by how much of it still overlaps the
+// trailing window. It smooths out the boundary burst of the fixed window
+// in constant memory.
+type slidingWindowLimiter struct {
+	limiterBase
+	limit       int
+	window      time.Duration
+	windowStart time.Time
+	prevCount   int
+	currCount   int
+}
+
+func newSlidingWindowLimiter(limit int, window time.Duration, clk clock.Clock) *slidingWindowLimiter {
+	return &slidingWindowLimiter{
+		limiterBase: newLimiterBase(clk),
+		limit:       limit,
+		window:      window,
+	}
+}
+
+func (s *slidingWindowLimiter) try(now time.Time) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.isClosed {
+		return 0, errLimiterClosed
+	}
+
+	start := now.Truncate(s.window)
+	switch {
+	case start.Equal(s.windowStart.Add(s.window)):
+		s.prevCount, s.currCount = s.currCount, 0
+		s.windowStart = start
+	case start.After(s.windowStart):
+		s.prevCount, s.currCount = 0, 0
+		s.windowStart = start
+	}
+
+	elapsed := now.Sub(s.windowStart)
+	overlap := 1 - float64(elapsed)/float64(s.window)
+	estimate := float64(s.prevCount)*overlap + float64(s.currCount)
+
+	if estimate+1 <= float64(s.limit) {
+		s.currCount++
+		return 0, nil
+	}
+
+	untilNextWindow := s.windowStart.Add(s.window).Sub(now)
+	if s.prevCount == 0 || s.currCount+1 > s.limit {
+		return untilNextWindow, nil
+	}
+
+	// Solve prevCount*(1-(elapsed+wait)/window)+currCount+1 <= limit
+	// for wait.
+	needed := 1 - float64(s.limit-s.currCount-1)/float64(s.prevCount)
+	wait := time.Duration(needed*float64(s.window)) - elapsed
+	return min(max(wait, time.Millisecond), untilNextWindow), nil
+}
+
+func (s *slidingWindowLimiter) Allow() bool {
+	wait, err := s.try(s.clock.Now())
+	return err == nil && wait <= 0
+}
+
+func (s *slidingWindowLimiter) Wait(ctx context.Context) error {
+	return waitFor(ctx, s.clock, s.quitChan, s.try)
+}